@@ -0,0 +1,124 @@
+package youtubedl
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+)
+
+// audioTags is the metadata written into extracted audio files.
+type audioTags struct {
+	title       string
+	artist      string
+	date        string
+	description string
+	cover       *audioCover
+}
+
+// audioCover is an image embedded as cover art.
+type audioCover struct {
+	mimeType string
+	width    uint
+	height   uint
+	data     []byte
+}
+
+// DownloadAudio downloads the best audio only format of video and writes it
+// to w as a standalone file, tagged with the title, author, publish date,
+// description and largest thumbnail of the video. MP4 formats are remuxed
+// to an M4A file and WebM Opus formats to an Ogg Opus file. It returns the
+// downloaded format, from which the file extension can be chosen.
+func (c *Client) DownloadAudio(ctx context.Context, video *Video, w io.Writer, opts ...DownloadOpts) (*Format, error) {
+	format, remux, err := bestAudioFormat(video.Formats)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, contextKey("info"), contextInfo{Self: c, Client: video.formatClient(format)})
+
+	tags := audioTags{
+		title:       video.Title,
+		artist:      video.Author,
+		description: video.Description,
+		cover:       c.getCover(ctx, video),
+	}
+	if !video.PublishDate.IsZero() {
+		tags.date = video.PublishDate.Format(dateFormat)
+	}
+
+	stream, _, err := c.GetStreamContext(ctx, video, format, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	if err := remux(w, stream, tags); err != nil {
+		return nil, err
+	}
+
+	return format, nil
+}
+
+// bestAudioFormat returns the best audio only format that can be remuxed
+// to a standalone file, with the function doing so.
+func bestAudioFormat(formats FormatList) (*Format, func(io.Writer, io.Reader, audioTags) error, error) {
+	candidates := formats.Select(func(f Format) bool {
+		if !f.IsAudioOnly() {
+			return false
+		}
+		switch f.Container() {
+		case "mp4":
+			return true
+		case "webm":
+			codec := f.AudioCodec()
+			return codec != nil && codec.Family == "opus"
+		}
+		return false
+	})
+	if len(candidates) == 0 {
+		return nil, nil, ErrNoAudioFormat
+	}
+
+	candidates.Sort()
+	format := &candidates[0]
+
+	if format.Container() == "webm" {
+		return format, remuxOggOpus, nil
+	}
+
+	return format, remuxM4A, nil
+}
+
+// getCover returns the largest thumbnail of video that can be downloaded,
+// or nil.
+func (c *Client) getCover(ctx context.Context, video *Video) *audioCover {
+	thumbnails := append([]Thumbnail{}, video.Thumbnails...)
+	sort.SliceStable(thumbnails, func(i, j int) bool {
+		return thumbnails[i].Width*thumbnails[i].Height > thumbnails[j].Width*thumbnails[j].Height
+	})
+
+	for _, thumbnail := range thumbnails {
+		uri, err := c.endpoints.imageURL(thumbnail.URL)
+		if err != nil {
+			continue
+		}
+
+		data, err := httpGetBodyBytes(ctx, uri)
+		if err != nil {
+			// the largest sizes are not generated for every video
+			slog.Debug("thumbnail unavailable", "url", uri, "error", err)
+			continue
+		}
+
+		return &audioCover{
+			mimeType: http.DetectContentType(data),
+			width:    thumbnail.Width,
+			height:   thumbnail.Height,
+			data:     data,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,476 @@
+package youtubedl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// m4aChunk is a run of contiguous samples in the mdat of an M4A file.
+type m4aChunk struct {
+	offset  int64 // relative to the mdat data
+	samples uint32
+}
+
+// m4aMaxOffset is the largest chunk offset a stco box holds, past which the
+// chunk offsets are written to a co64 box. Tests lower it.
+var m4aMaxOffset int64 = math.MaxUint32
+
+// remuxM4A remuxes the single track fragmented MP4 r to a non-fragmented
+// M4A file with its moov first, tagged with tags. As the moov holds the
+// offsets of the samples, those are spooled to a temporary file first.
+func remuxM4A(w io.Writer, r io.Reader, tags audioTags) error {
+	tmp, err := os.CreateTemp("", "youtubedl-*.m4a")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	stream := &mp4Stream{r: r}
+	spool := &countingWriter{w: tmp}
+
+	var moov *mp4Box
+	var defaults map[uint32]mp4TrackDefaults
+	var samples []mp4Sample
+	var chunks []m4aChunk
+
+	for {
+		box, err := stream.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		switch box.typ {
+		case "moov":
+			moov = box
+			if defaults, err = moovTrackDefaults(moov); err != nil {
+				return err
+			}
+
+		case "moof":
+			if moov == nil {
+				return fmt.Errorf("mp4: moof before moov at offset %d", box.offset)
+			}
+
+			// the samples are in the boxes up to the next moof
+			var boxes []*mp4Box
+			for {
+				next, err := stream.peek()
+				if err == io.EOF || err == nil && next.typ == "moof" {
+					break
+				} else if err != nil {
+					return err
+				}
+				stream.next() //nolint:errcheck
+				boxes = append(boxes, next)
+			}
+
+			fragments, err := parseMP4Fragments(box, defaults)
+			if err != nil {
+				return err
+			}
+
+			for _, f := range fragments {
+				chunk := m4aChunk{offset: spool.n}
+				for _, s := range f.samples {
+					data := mp4SampleData(boxes, s)
+					if data == nil {
+						return fmt.Errorf("mp4: sample at offset %d is outside the fragment", s.offset)
+					}
+
+					s.offset = spool.n
+					if _, err := spool.Write(data); err != nil {
+						return err
+					}
+					samples = append(samples, s)
+					chunk.samples++
+				}
+				if chunk.samples > 0 {
+					chunks = append(chunks, chunk)
+				}
+			}
+		}
+	}
+
+	if moov == nil {
+		return fmt.Errorf("mp4: no moov box")
+	}
+
+	ftyp := mp4BoxBytes("ftyp", false, []byte("M4A "), []byte{0, 0, 2, 0}, []byte("M4A mp42isom"))
+
+	mdatHeader := make([]byte, 8, 16)
+	if spool.n+8 > math.MaxUint32 {
+		mdatHeader = binary.BigEndian.AppendUint64(mdatHeader, uint64(spool.n+16))
+		binary.BigEndian.PutUint32(mdatHeader, 1)
+	} else {
+		binary.BigEndian.PutUint32(mdatHeader, uint32(spool.n+8))
+	}
+	copy(mdatHeader[4:8], "mdat")
+
+	// the size of the moov does not depend on the chunk offsets, only on
+	// their width: choose the width from the layout with 32-bit offsets,
+	// then measure the moov with that width to place the mdat
+	layout, err := buildM4AMoov(moov, samples, chunks, 0, false, tags)
+	if err != nil {
+		return err
+	}
+	large := int64(len(ftyp)+len(layout)+len(mdatHeader))+spool.n > m4aMaxOffset
+	if large {
+		if layout, err = buildM4AMoov(moov, samples, chunks, 0, true, tags); err != nil {
+			return err
+		}
+	}
+	base := int64(len(ftyp) + len(layout) + len(mdatHeader))
+
+	out, err := buildM4AMoov(moov, samples, chunks, base, large, tags)
+	if err != nil {
+		return err
+	}
+
+	out = append(append(ftyp, out...), mdatHeader...)
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, tmp)
+
+	return err
+}
+
+// moovTrackDefaults returns the sample defaults of the trex boxes of moov.
+func moovTrackDefaults(moov *mp4Box) (map[uint32]mp4TrackDefaults, error) {
+	defaults := make(map[uint32]mp4TrackDefaults)
+
+	boxes, err := readMP4Boxes(moov.data)
+	if err != nil {
+		return nil, err
+	}
+
+	mvex := findMP4Box(boxes, "mvex")
+	if mvex == nil {
+		return defaults, nil
+	}
+
+	children, err := readMP4Boxes(mvex.data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range children {
+		if children[i].typ == "trex" {
+			id, d := parseTrex(&children[i])
+			defaults[id] = d
+		}
+	}
+
+	return defaults, nil
+}
+
+// mp4SampleData returns the data of s from the boxes holding it, or nil.
+func mp4SampleData(boxes []*mp4Box, s mp4Sample) []byte {
+	for _, box := range boxes {
+		start := s.offset - box.offset - int64(box.header)
+		if start >= 0 && start+int64(s.size) <= int64(len(box.data)) {
+			return box.data[start : start+int64(s.size)]
+		}
+	}
+
+	return nil
+}
+
+// buildM4AMoov returns the moov of the input with the sample tables built
+// from samples and chunks, whose offsets are relative to base, and with
+// the udta holding tags. Fragment defaults are dropped.
+func buildM4AMoov(moov *mp4Box, samples []mp4Sample, chunks []m4aChunk, base int64, large bool, tags audioTags) ([]byte, error) {
+	boxes, err := readMP4Boxes(moov.data)
+	if err != nil {
+		return nil, err
+	}
+
+	var duration uint64
+	for _, s := range samples {
+		duration += uint64(s.duration)
+	}
+
+	var trak *mp4Box
+	for i := range boxes {
+		if boxes[i].typ != "trak" {
+			continue
+		}
+		if trak != nil {
+			return nil, fmt.Errorf("mp4: more than one track")
+		}
+		trak = &boxes[i]
+	}
+	if trak == nil {
+		return nil, fmt.Errorf("mp4: no trak box")
+	}
+
+	trakBoxes, err := readMP4Boxes(trak.data)
+	if err != nil {
+		return nil, err
+	}
+	mdhd, err := mp4Path(trakBoxes, "mdia", "mdhd")
+	if err != nil {
+		return nil, err
+	}
+	timescale := mdhdTimescale(mdhd)
+	if timescale == 0 {
+		return nil, fmt.Errorf("mp4: track has no timescale")
+	}
+
+	// mvhd and tkhd use the movie timescale, which has the same offset
+	mvhd := findMP4Box(boxes, "mvhd")
+	if mvhd == nil {
+		return nil, fmt.Errorf("mp4: no mvhd box")
+	}
+	movieTimescale := mdhdTimescale(mvhd)
+	movieDuration := duration * uint64(movieTimescale) / uint64(timescale)
+
+	var children [][]byte
+	for _, box := range boxes {
+		switch box.typ {
+		case "mvhd":
+			data := append([]byte{}, box.data...)
+			setMP4Duration(box.typ, data, movieDuration)
+			children = append(children, mp4BoxBytes("mvhd", false, data))
+		case "trak":
+			trak, err := buildM4ATrak(trakBoxes, samples, chunks, base, large, duration, movieDuration)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, trak)
+		case "mvex", "udta":
+			// fragment defaults, and tags replaced below
+		default:
+			children = append(children, box.bytes())
+		}
+	}
+	children = append(children, m4aUdta(tags))
+
+	return mp4BoxBytes("moov", false, children...), nil
+}
+
+// buildM4ATrak returns the trak with its durations set, in the media and
+// movie timescales, and its sample tables built.
+func buildM4ATrak(boxes []mp4Box, samples []mp4Sample, chunks []m4aChunk, base int64, large bool, duration, movieDuration uint64) ([]byte, error) {
+	var trak [][]byte
+	for _, box := range boxes {
+		switch box.typ {
+		case "tkhd":
+			tkhd := append([]byte{}, box.data...)
+			setMP4Duration(box.typ, tkhd, movieDuration)
+			trak = append(trak, mp4BoxBytes("tkhd", false, tkhd))
+
+		case "mdia":
+			mdia, err := readMP4Boxes(box.data)
+			if err != nil {
+				return nil, err
+			}
+
+			var children [][]byte
+			for _, child := range mdia {
+				switch child.typ {
+				case "mdhd":
+					mdhd := append([]byte{}, child.data...)
+					setMP4Duration(child.typ, mdhd, duration)
+					children = append(children, mp4BoxBytes("mdhd", false, mdhd))
+				case "minf":
+					minf, err := buildM4AMinf(child.data, samples, chunks, base, large)
+					if err != nil {
+						return nil, err
+					}
+					children = append(children, minf)
+				default:
+					children = append(children, child.bytes())
+				}
+			}
+			trak = append(trak, mp4BoxBytes("mdia", false, children...))
+
+		default:
+			trak = append(trak, box.bytes())
+		}
+	}
+
+	return mp4BoxBytes("trak", false, trak...), nil
+}
+
+// buildM4AMinf returns the minf box with the sample tables of its stbl
+// replaced by those of samples.
+func buildM4AMinf(data []byte, samples []mp4Sample, chunks []m4aChunk, base int64, large bool) ([]byte, error) {
+	minf, err := readMP4Boxes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var children [][]byte
+	for _, box := range minf {
+		if box.typ != "stbl" {
+			children = append(children, box.bytes())
+			continue
+		}
+
+		stbl, err := readMP4Boxes(box.data)
+		if err != nil {
+			return nil, err
+		}
+
+		var tables [][]byte
+		for _, table := range stbl {
+			switch table.typ {
+			case "stts", "ctts", "stsc", "stsz", "stz2", "stco", "co64", "stss":
+				// empty in fragmented files
+			default:
+				tables = append(tables, table.bytes())
+			}
+		}
+		tables = append(tables, m4aSampleTables(samples, chunks, base, large)...)
+
+		children = append(children, mp4BoxBytes("stbl", false, tables...))
+	}
+
+	return mp4BoxBytes("minf", false, children...), nil
+}
+
+// m4aSampleTables returns the stts, ctts, stsc, stsz and stco or co64
+// boxes describing samples.
+func m4aSampleTables(samples []mp4Sample, chunks []m4aChunk, base int64, large bool) [][]byte {
+	// runs of equal values, as (count, value) pairs
+	type run struct{ count, value uint32 }
+	runs := func(values func(i int) uint32) []run {
+		var out []run
+		for i := range samples {
+			v := values(i)
+			if n := len(out); n > 0 && out[n-1].value == v {
+				out[n-1].count++
+			} else {
+				out = append(out, run{1, v})
+			}
+		}
+		return out
+	}
+	table := func(typ string, version byte, runs []run) []byte {
+		data := []byte{version, 0, 0, 0}
+		data = binary.BigEndian.AppendUint32(data, uint32(len(runs)))
+		for _, r := range runs {
+			data = binary.BigEndian.AppendUint32(data, r.count)
+			data = binary.BigEndian.AppendUint32(data, r.value)
+		}
+		return mp4BoxBytes(typ, false, data)
+	}
+
+	var boxes [][]byte
+	boxes = append(boxes, table("stts", 0, runs(func(i int) uint32 { return samples[i].duration })))
+
+	cto := runs(func(i int) uint32 { return uint32(samples[i].cto) })
+	if len(cto) > 1 || len(cto) == 1 && cto[0].value != 0 {
+		boxes = append(boxes, table("ctts", 1, cto))
+	}
+
+	stsc := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	entries := uint32(0)
+	for i, c := range chunks {
+		if i > 0 && chunks[i-1].samples == c.samples {
+			continue
+		}
+		stsc = binary.BigEndian.AppendUint32(stsc, uint32(i+1))
+		stsc = binary.BigEndian.AppendUint32(stsc, c.samples)
+		stsc = binary.BigEndian.AppendUint32(stsc, 1) // sample_description_index
+		entries++
+	}
+	binary.BigEndian.PutUint32(stsc[4:], entries)
+	boxes = append(boxes, mp4BoxBytes("stsc", false, stsc))
+
+	stsz := make([]byte, 8, 12+4*len(samples))
+	stsz = binary.BigEndian.AppendUint32(stsz, uint32(len(samples)))
+	for _, s := range samples {
+		stsz = binary.BigEndian.AppendUint32(stsz, s.size)
+	}
+	boxes = append(boxes, mp4BoxBytes("stsz", false, stsz))
+
+	typ, offsets := "stco", []byte{0, 0, 0, 0}
+	if large {
+		typ = "co64"
+	}
+	offsets = binary.BigEndian.AppendUint32(offsets, uint32(len(chunks)))
+	for _, c := range chunks {
+		if large {
+			offsets = binary.BigEndian.AppendUint64(offsets, uint64(base+c.offset))
+		} else {
+			offsets = binary.BigEndian.AppendUint32(offsets, uint32(base+c.offset))
+		}
+	}
+	boxes = append(boxes, mp4BoxBytes(typ, false, offsets))
+
+	return boxes
+}
+
+// setMP4Duration sets the duration of a mvhd, tkhd or mdhd box.
+func setMP4Duration(typ string, data []byte, duration uint64) {
+	if len(data) == 0 {
+		return
+	}
+
+	// after the version, flags, creation and modification times and the
+	// timescale, or the track ID and a reserved field for tkhd
+	offset, width := 16, 4
+	if data[0] == 1 {
+		offset, width = 24, 8
+	}
+	if typ == "tkhd" {
+		offset += 4
+	}
+
+	if len(data) < offset+width {
+		return
+	}
+	if width == 8 {
+		binary.BigEndian.PutUint64(data[offset:], duration)
+	} else {
+		binary.BigEndian.PutUint32(data[offset:], uint32(min(duration, math.MaxUint32)))
+	}
+}
+
+// m4aUdta returns the udta box holding tags as iTunes metadata.
+func m4aUdta(tags audioTags) []byte {
+	item := func(name string, typ uint32, value []byte) []byte {
+		data := binary.BigEndian.AppendUint32(nil, typ)
+		data = append(data, 0, 0, 0, 0) // locale
+		return mp4BoxBytes(name, false, mp4BoxBytes("data", false, data, value))
+	}
+
+	var items [][]byte
+	for _, text := range []struct{ name, value string }{
+		{"\xa9nam", tags.title},
+		{"\xa9ART", tags.artist},
+		{"\xa9day", tags.date},
+		{"desc", tags.description},
+		{"\xa9too", muxingApp},
+	} {
+		if text.value != "" {
+			items = append(items, item(text.name, 1, []byte(text.value)))
+		}
+	}
+
+	if cover := tags.cover; cover != nil {
+		switch {
+		case strings.HasPrefix(cover.mimeType, "image/jpeg"):
+			items = append(items, item("covr", 13, cover.data))
+		case strings.HasPrefix(cover.mimeType, "image/png"):
+			items = append(items, item("covr", 14, cover.data))
+		}
+	}
+
+	hdlr := mp4BoxBytes("hdlr", false, make([]byte, 8), []byte("mdirappl"), make([]byte, 9))
+	meta := mp4BoxBytes("meta", false, make([]byte, 4), hdlr, mp4BoxBytes("ilst", false, items...))
+
+	return mp4BoxBytes("udta", false, meta)
+}
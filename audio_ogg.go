@@ -0,0 +1,266 @@
+package youtubedl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// oggPageSize is the payload size after which a page is written, as done
+// by libogg.
+const oggPageSize = 4096
+
+// opusSampleRate is the rate of Ogg Opus granule positions.
+const opusSampleRate = 48000
+
+// remuxOggOpus remuxes the single Opus track WebM r to an Ogg Opus file
+// tagged with tags.
+func remuxOggOpus(w io.Writer, r io.Reader, tags audioTags) error {
+	in := &webmInput{stream: &ebmlStream{r: r}, track: 1}
+	if _, err := in.readHeaders(); err != nil {
+		return fmt.Errorf("webm: %w", err)
+	}
+
+	fields, err := readEBMLElements(in.trackEntry)
+	if err != nil {
+		return err
+	}
+	if codec := findEBMLElement(fields, ebmlIDCodecID); codec == nil || string(codec.data) != "A_OPUS" {
+		return fmt.Errorf("%w: webm track is not Opus", ErrUnsupportedContainer)
+	}
+	head := findEBMLElement(fields, ebmlIDCodecPrivate)
+	if head == nil || len(head.data) < 19 || !bytes.HasPrefix(head.data, []byte("OpusHead")) {
+		return fmt.Errorf("webm: Opus track without OpusHead")
+	}
+
+	ogg := &oggWriter{w: w, serial: rand.Uint32()} //nolint:gosec
+
+	// the headers are alone on their pages
+	for _, header := range [][]byte{head.data, opusTags(tags)} {
+		if err := ogg.writePacket(header, 0); err != nil {
+			return err
+		}
+		if err := ogg.flush(false); err != nil {
+			return err
+		}
+	}
+
+	// granule positions count the samples decoded, pre-skip included
+	granule := int64(binary.LittleEndian.Uint16(head.data[10:12]))
+	for {
+		if err := in.fill(); err != nil {
+			return err
+		}
+		if len(in.blocks) == 0 {
+			break
+		}
+
+		for _, block := range in.blocks {
+			packet, padding, err := webmBlockFrame(block)
+			if err != nil {
+				return err
+			}
+
+			granule += int64(opusPacketSamples(packet)) - int64(padding)*opusSampleRate/int64(time.Second)
+			if err := ogg.writePacket(packet, granule); err != nil {
+				return err
+			}
+		}
+		in.blocks = nil
+	}
+
+	return ogg.flush(true)
+}
+
+// opusTags returns the OpusTags header holding tags as Vorbis comments.
+func opusTags(tags audioTags) []byte {
+	var comments []string
+	for _, c := range []struct{ name, value string }{
+		{"TITLE", tags.title},
+		{"ARTIST", tags.artist},
+		{"DATE", tags.date},
+		{"DESCRIPTION", tags.description},
+	} {
+		if c.value != "" {
+			comments = append(comments, c.name+"="+c.value)
+		}
+	}
+	if tags.cover != nil {
+		comments = append(comments, "METADATA_BLOCK_PICTURE="+base64.StdEncoding.EncodeToString(flacPicture(tags.cover)))
+	}
+
+	out := []byte("OpusTags")
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(muxingApp)))
+	out = append(out, muxingApp...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(comments)))
+	for _, c := range comments {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(c)))
+		out = append(out, c...)
+	}
+
+	return out
+}
+
+// flacPicture returns cover as a FLAC picture block, the front cover.
+func flacPicture(cover *audioCover) []byte {
+	out := binary.BigEndian.AppendUint32(nil, 3)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(cover.mimeType)))
+	out = append(out, cover.mimeType...)
+	out = binary.BigEndian.AppendUint32(out, 0) // description
+	out = binary.BigEndian.AppendUint32(out, uint32(cover.width))
+	out = binary.BigEndian.AppendUint32(out, uint32(cover.height))
+	out = binary.BigEndian.AppendUint32(out, 24) // color depth
+	out = binary.BigEndian.AppendUint32(out, 0)  // indexed colors
+	out = binary.BigEndian.AppendUint32(out, uint32(len(cover.data)))
+
+	return append(out, cover.data...)
+}
+
+// opusPacketSamples returns the number of 48 kHz samples of an Opus packet,
+// read from its TOC byte.
+func opusPacketSamples(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+
+	toc := packet[0]
+	config := toc >> 3
+
+	var frame int
+	switch {
+	case config < 12: // SILK, 10 to 60 ms
+		frame = []int{480, 960, 1920, 2880}[config&3]
+	case config < 16: // hybrid, 10 or 20 ms
+		frame = []int{480, 960}[config&1]
+	default: // CELT, 2.5 to 20 ms
+		frame = []int{120, 240, 480, 960}[config&3]
+	}
+
+	switch toc & 3 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	}
+
+	// an arbitrary number of frames
+	if len(packet) < 2 {
+		return 0
+	}
+
+	return int(packet[1]&0x3f) * frame
+}
+
+// oggWriter writes the packets of a single logical Ogg stream.
+type oggWriter struct {
+	w        io.Writer
+	serial   uint32
+	sequence uint32
+
+	// the pending page
+	segments  []byte
+	data      []byte
+	continued bool  // starts with the rest of a packet
+	completed bool  // a packet ends on it
+	granule   int64 // of the last packet ending on it
+}
+
+// writePacket adds packet, whose granule position is granule, to the
+// pending page, writing pages as they fill up.
+func (o *oggWriter) writePacket(packet []byte, granule int64) error {
+	if len(o.data) >= oggPageSize {
+		if err := o.flush(false); err != nil {
+			return err
+		}
+	}
+
+	for first := true; ; first = false {
+		if len(o.segments) == 255 {
+			if err := o.flush(false); err != nil {
+				return err
+			}
+			o.continued = !first
+		}
+
+		// lacing values of 255 continue the packet
+		n := min(len(packet), 255)
+		o.segments = append(o.segments, byte(n))
+		o.data = append(o.data, packet[:n]...)
+		packet = packet[n:]
+
+		if n < 255 {
+			break
+		}
+	}
+
+	o.completed = true
+	o.granule = granule
+
+	return nil
+}
+
+// flush writes the pending page, the last of the stream with eos.
+func (o *oggWriter) flush(eos bool) error {
+	page := make([]byte, 27, 27+len(o.segments)+len(o.data))
+	copy(page, "OggS")
+
+	if o.continued {
+		page[5] |= 0x01
+	}
+	if o.sequence == 0 {
+		page[5] |= 0x02
+	}
+	if eos {
+		page[5] |= 0x04
+	}
+
+	granule := int64(-1)
+	if o.completed {
+		granule = o.granule
+	}
+	binary.LittleEndian.PutUint64(page[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:], o.serial)
+	binary.LittleEndian.PutUint32(page[18:], o.sequence)
+	page[26] = byte(len(o.segments))
+
+	page = append(append(page, o.segments...), o.data...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+
+	o.sequence++
+	o.segments, o.data = o.segments[:0], o.data[:0]
+	o.continued, o.completed = false, false
+
+	_, err := o.w.Write(page)
+
+	return err
+}
+
+// oggCRCTable is the table of the CRC-32 of Ogg pages, with polynomial
+// 0x04c11db7 and no bit reflection.
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+
+	return crc
+}
@@ -0,0 +1,374 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// m4aSamples returns the concatenated samples of the single track of a
+// non-fragmented MP4, following its sample tables.
+func m4aSamples(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	boxes, err := readMP4Boxes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stbl, err := mp4Path(boxes, "moov", "trak", "mdia", "minf", "stbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, _ := readMP4Boxes(stbl.data)
+
+	u32s := func(typ string, skip int) []uint32 {
+		box := findMP4Box(tables, typ)
+		if box == nil {
+			t.Fatalf("no %s box", typ)
+		}
+		var out []uint32
+		for b := box.data[skip:]; len(b) >= 4; b = b[4:] {
+			out = append(out, binary.BigEndian.Uint32(b))
+		}
+		return out
+	}
+
+	sizes := u32s("stsz", 12)
+	stsc := u32s("stsc", 8)
+
+	var offsets []uint64
+	if co64 := findMP4Box(tables, "co64"); co64 != nil {
+		for b := co64.data[8:]; len(b) >= 8; b = b[8:] {
+			offsets = append(offsets, binary.BigEndian.Uint64(b))
+		}
+	} else {
+		for _, offset := range u32s("stco", 8) {
+			offsets = append(offsets, uint64(offset))
+		}
+	}
+
+	var out []byte
+	sample := 0
+	for chunk, offset := range offsets {
+		// the last entry starting at or before the chunk applies
+		perChunk := uint32(0)
+		for i := 0; i+2 < len(stsc); i += 3 {
+			if int(stsc[i]) <= chunk+1 {
+				perChunk = stsc[i+1]
+			}
+		}
+
+		for range perChunk {
+			out = append(out, data[offset:offset+uint64(sizes[sample])]...)
+			offset += uint64(sizes[sample])
+			sample++
+		}
+	}
+	if sample != len(sizes) {
+		t.Errorf("chunks hold %d samples, stsz %d", sample, len(sizes))
+	}
+
+	return out
+}
+
+// ilstItems returns the values of the iTunes metadata items of an MP4.
+func ilstItems(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	boxes, _ := readMP4Boxes(data)
+	meta, err := mp4Path(boxes, "moov", "udta", "meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, _ := readMP4Boxes(meta.data[4:])
+	ilst := findMP4Box(children, "ilst")
+	if ilst == nil {
+		t.Fatal("no ilst box")
+	}
+
+	items := map[string][]byte{}
+	list, _ := readMP4Boxes(ilst.data)
+	for _, item := range list {
+		value, _ := readMP4Boxes(item.data)
+		items[item.typ] = findMP4Box(value, "data").data[8:]
+	}
+
+	return items
+}
+
+func TestDownloadAudio(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureMediaID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	format, err := client.DownloadAudio(context.Background(), video, &out)
+	if err != nil {
+		t.Fatalf("DownloadAudio failed: %v", err)
+	}
+	if format.ItagNo != 140 {
+		t.Errorf("itag = %d, want 140", format.ItagNo)
+	}
+
+	boxes, err := readMP4Boxes(out.Bytes())
+	if err != nil {
+		t.Fatalf("output is not a valid MP4: %v", err)
+	}
+	var types []string
+	for _, b := range boxes {
+		types = append(types, b.typ)
+	}
+	if strings.Join(types, " ") != "ftyp moov mdat" {
+		t.Errorf("top-level boxes = %v", types)
+	}
+	if brand := string(boxes[0].data[:4]); brand != "M4A " {
+		t.Errorf("major brand = %q", brand)
+	}
+	if _, err := mp4Path(boxes, "moov", "mvex"); err == nil {
+		t.Error("moov still has a mvex box")
+	}
+
+	input, err := os.ReadFile("testdata/videoplayback/o-fixture2.140")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, fragments := fmp4TrackData(t, input)
+	if !bytes.Equal(m4aSamples(t, out.Bytes()), want[1]) {
+		t.Error("samples differ from the input")
+	}
+
+	var duration uint64
+	for _, f := range fragments {
+		duration += f.duration()
+	}
+	mdhd, _ := mp4Path(boxes, "moov", "trak", "mdia", "mdhd")
+	if got := uint64(binary.BigEndian.Uint32(mdhd.data[16:])); got != duration {
+		t.Errorf("mdhd duration = %d, want %d", got, duration)
+	}
+
+	cover, err := os.ReadFile("testdata/vi/fixture0002/maxresdefault.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := ilstItems(t, out.Bytes())
+	for name, want := range map[string]string{
+		"\xa9nam": "Fixture Media",
+		"\xa9ART": "Fixture Channel",
+		"\xa9day": "2024-01-02",
+		"desc":    "Synthetic fragmented MP4 and WebM streams.",
+		"covr":    string(cover),
+	} {
+		if got := string(items[name]); got != want {
+			t.Errorf("%q = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRemuxM4ALargeOffsets(t *testing.T) {
+	input, err := os.ReadFile("testdata/videoplayback/o-fixture2.140")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// any output needs 64-bit chunk offsets
+	defer func(max int64) { m4aMaxOffset = max }(m4aMaxOffset)
+	m4aMaxOffset = 1024
+
+	var out bytes.Buffer
+	if err := remuxM4A(&out, bytes.NewReader(input), audioTags{title: "Fixture Media"}); err != nil {
+		t.Fatalf("remuxM4A failed: %v", err)
+	}
+
+	boxes, err := readMP4Boxes(out.Bytes())
+	if err != nil {
+		t.Fatalf("output is not a valid MP4: %v", err)
+	}
+	stbl, err := mp4Path(boxes, "moov", "trak", "mdia", "minf", "stbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, _ := readMP4Boxes(stbl.data)
+	if findMP4Box(tables, "co64") == nil || findMP4Box(tables, "stco") != nil {
+		t.Fatal("chunk offsets are not in a co64 box")
+	}
+
+	want, _ := fmp4TrackData(t, input)
+	if !bytes.Equal(m4aSamples(t, out.Bytes()), want[1]) {
+		t.Error("samples differ from the input")
+	}
+}
+
+// oggPackets returns the packets of an Ogg stream and the granule position
+// of its last page, checking the framing of its pages.
+func oggPackets(t *testing.T, data []byte) ([][]byte, int64) {
+	t.Helper()
+
+	var packets [][]byte
+	var packet []byte
+	var granule int64
+
+	for sequence := uint32(0); len(data) > 0; sequence++ {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("page %d has no capture pattern", sequence)
+		}
+
+		flags := data[5]
+		if got := flags&0x02 != 0; got != (sequence == 0) {
+			t.Errorf("page %d: beginning of stream flag is %v", sequence, got)
+		}
+		if got := binary.LittleEndian.Uint32(data[18:]); got != sequence {
+			t.Errorf("page %d has sequence number %d", sequence, got)
+		}
+		if got := flags&0x01 != 0; got != (packet != nil) {
+			t.Errorf("page %d: continued flag is %v", sequence, got)
+		}
+
+		segments := data[27 : 27+int(data[26])]
+		size := 27 + len(segments)
+		for _, s := range segments {
+			size += int(s)
+		}
+		page := append([]byte{}, data[:size]...)
+		crc := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		if oggCRC(page) != crc {
+			t.Errorf("page %d has an invalid CRC", sequence)
+		}
+
+		body := data[27+len(segments) : size]
+		for _, s := range segments {
+			packet = append(packet, body[:s]...)
+			body = body[s:]
+			if s < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+
+		granule = int64(binary.LittleEndian.Uint64(data[6:]))
+		data = data[size:]
+
+		if eos := flags&0x04 != 0; eos != (len(data) == 0) {
+			t.Errorf("page %d: end of stream flag is %v", sequence, eos)
+		}
+	}
+
+	return packets, granule
+}
+
+func TestRemuxOggOpus(t *testing.T) {
+	input, err := os.ReadFile("testdata/videoplayback/o-fixture2.251")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a cover large enough for the tags to span several pages
+	cover := make([]byte, 100000)
+	rand.Read(cover) //nolint:errcheck
+
+	tags := audioTags{
+		title:  "Fixture Media",
+		artist: "Fixture Channel",
+		date:   "2024-01-02",
+		cover:  &audioCover{mimeType: "image/jpeg", width: 1280, height: 720, data: cover},
+	}
+
+	var out bytes.Buffer
+	if err := remuxOggOpus(&out, bytes.NewReader(input), tags); err != nil {
+		t.Fatalf("remuxOggOpus failed: %v", err)
+	}
+
+	packets, granule := oggPackets(t, out.Bytes())
+	if len(packets) < 2 {
+		t.Fatalf("%d packets", len(packets))
+	}
+
+	if !bytes.HasPrefix(packets[0], []byte("OpusHead")) {
+		t.Errorf("first packet is not an OpusHead")
+	}
+	preSkip := int64(binary.LittleEndian.Uint16(packets[0][10:]))
+
+	comments := string(packets[1])
+	if !strings.HasPrefix(comments, "OpusTags") {
+		t.Errorf("second packet is not an OpusTags")
+	}
+	for _, want := range []string{"TITLE=Fixture Media", "ARTIST=Fixture Channel", "DATE=2024-01-02"} {
+		if !strings.Contains(comments, want) {
+			t.Errorf("tags lack %q", want)
+		}
+	}
+	picture := base64.StdEncoding.EncodeToString(flacPicture(tags.cover))
+	if !strings.Contains(comments, "METADATA_BLOCK_PICTURE="+picture) {
+		t.Errorf("tags lack the picture")
+	}
+
+	frames := webmFrames(t, input)
+	audio := packets[2:]
+	if len(audio) != len(frames) {
+		t.Fatalf("%d audio packets, want %d", len(audio), len(frames))
+	}
+
+	samples := preSkip
+	for i := range frames {
+		if !bytes.Equal(audio[i], frames[i].data) {
+			t.Errorf("packet %d differs from the input", i)
+		}
+		samples += int64(opusPacketSamples(audio[i]))
+	}
+	if granule != samples {
+		t.Errorf("final granule position = %d, want %d", granule, samples)
+	}
+}
+
+func TestOpusPacketSamples(t *testing.T) {
+	for _, tt := range []struct {
+		packet []byte
+		want   int
+	}{
+		{nil, 0},
+		{[]byte{0x08}, 960},           // SILK NB 20 ms
+		{[]byte{0x78}, 960},           // hybrid FB 20 ms
+		{[]byte{0xF8}, 960},           // CELT FB 20 ms
+		{[]byte{0xE0}, 120},           // CELT FB 2.5 ms
+		{[]byte{0xF9}, 1920},          // two frames
+		{[]byte{0xFB, 0x03}, 2880},    // three frames, code 3
+		{[]byte{0x1B, 0x02}, 5760},    // two 60 ms SILK frames
+		{[]byte{0xFB}, 0},             // truncated code 3
+		{[]byte{0x0A, 0xFF}, 2 * 960}, // code 2
+	} {
+		if got := opusPacketSamples(tt.packet); got != tt.want {
+			t.Errorf("opusPacketSamples(%x) = %d, want %d", tt.packet, got, tt.want)
+		}
+	}
+}
+
+func TestBestAudioFormat(t *testing.T) {
+	mp4Video := Format{ItagNo: 136, MimeType: `video/mp4; codecs="avc1.4d401f"`, Width: 1280}
+	mp4Audio := Format{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AudioChannels: 2, Bitrate: 130000}
+	opus := Format{ItagNo: 251, MimeType: `audio/webm; codecs="opus"`, AudioChannels: 2, Bitrate: 140000}
+	vorbis := Format{ItagNo: 171, MimeType: `audio/webm; codecs="vorbis"`, AudioChannels: 2}
+
+	format, remux, err := bestAudioFormat(FormatList{mp4Video, opus, mp4Audio})
+	if err != nil || format.ItagNo != 140 || remux == nil {
+		t.Errorf("mp4 and opus: itag %v, err %v", format, err)
+	}
+
+	format, _, err = bestAudioFormat(FormatList{mp4Video, vorbis, opus})
+	if err != nil || format.ItagNo != 251 {
+		t.Errorf("opus only: itag %v, err %v", format, err)
+	}
+
+	if _, _, err := bestAudioFormat(FormatList{mp4Video, vorbis}); !errors.Is(err, ErrNoAudioFormat) {
+		t.Errorf("no audio: err = %v, want ErrNoAudioFormat", err)
+	}
+}
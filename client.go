@@ -11,13 +11,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/mengzhuo/cookiestxt"
 )
@@ -26,14 +29,35 @@ var defaultYoutubeClient = "WEB"
 var ErrNoFormat = errors.New("no video format provided")
 
 type Client struct {
+	httpClient  *http.Client
+	endpoints   Endpoints
+	playerStore PlayerStore
+	nsigCache   *NSigCache
+
+	// player is loaded on first use, see getPlayer
+	playerMu   sync.Mutex
 	player     *Player
-	httpClient *http.Client
+	playerLoad *playerLoad // in flight, nil if none
+
+	visitorMu   sync.Mutex
+	visitorData string
+
+	// limiter enforces the rate limit shared by all downloads, see SetRateLimit
+	limiter *rateLimiter
 
 	// MaxRoutines to use when downloading a video.
 	MaxRoutines int
 
 	// ChunkSize to use when downloading videos in chunks. Default is Size10Mb.
 	ChunkSize int64
+
+	// RetryPolicy for failed requests and chunks. Default is DefaultRetryPolicy.
+	RetryPolicy *RetryPolicy
+
+	// MaxBufferedBytes bounds the memory of a chunked download, counting the
+	// chunks being downloaded and those waiting to be read. At least one chunk
+	// is always buffered. Default is MaxRoutines chunks.
+	MaxBufferedBytes int64
 }
 
 type YoutubeClient struct {
@@ -85,8 +109,75 @@ type innertubeClient struct {
 	VisitorData       string `json:"visitorData,omitempty"`
 }
 
+// Endpoints holds the hosts the client sends its requests to.
+type Endpoints struct {
+	// Base is used for the InnerTube API, iframe_api, base.js, sw.js_data
+	// and watch page requests. Defaults to URLs.YTBase.
+	Base string
+
+	// Stream, if set, replaces the scheme and host of every stream URL,
+	// keeping its path and query.
+	Stream string
+
+	// Image, if set, replaces the scheme and host of thumbnail URLs.
+	Image string
+}
+
+// url returns the absolute URL for path p on the base host.
+func (e Endpoints) url(p string) (*url.URL, error) {
+	base := e.Base
+	if base == "" {
+		base = URLs.YTBase
+	}
+
+	uri, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	uri.Path = path.Join(uri.Path, p)
+
+	return uri, nil
+}
+
+// streamURL rewrites a googlevideo URL to the configured stream host.
+func (e Endpoints) streamURL(s string) (string, error) {
+	return rewriteHost(s, e.Stream)
+}
+
+// imageURL rewrites a ytimg URL to the configured image host.
+func (e Endpoints) imageURL(s string) (string, error) {
+	return rewriteHost(s, e.Image)
+}
+
+// rewriteHost replaces the scheme and host of s by those of host, if set.
+func rewriteHost(s, host string) (string, error) {
+	if host == "" {
+		return s, nil
+	}
+
+	base, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+
+	uri, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	uri.Scheme = base.Scheme
+	uri.Host = base.Host
+	uri.Path = path.Join(base.Path, uri.Path)
+
+	return uri.String(), nil
+}
+
 type clientoptions struct {
-	httpClient *http.Client
+	httpClient  *http.Client
+	endpoints   Endpoints
+	playerStore PlayerStore
+	nsigCache   *NSigCache
+	nsigSet     bool
+	rateLimit   int64
 }
 
 type ClientOpts func(*clientoptions)
@@ -97,7 +188,38 @@ func WithHTTPClient(client *http.Client) ClientOpts {
 	}
 }
 
-func New(opts ...ClientOpts) (out *Client, err error) {
+// WithBaseURL sends all non-stream requests to base instead of URLs.YTBase.
+func WithBaseURL(base string) ClientOpts {
+	return func(o *clientoptions) {
+		o.endpoints.Base = base
+	}
+}
+
+// WithPlayerStore persists the extracted player code in store, so a new
+// process can skip downloading and parsing base.js for a known player.
+func WithPlayerStore(store PlayerStore) ClientOpts {
+	return func(o *clientoptions) {
+		o.playerStore = store
+	}
+}
+
+// WithNSigCache caches deciphered n parameters in cache instead of the
+// shared default cache. A nil cache disables caching.
+func WithNSigCache(cache *NSigCache) ClientOpts {
+	return func(o *clientoptions) {
+		o.nsigCache = cache
+		o.nsigSet = true
+	}
+}
+
+// WithEndpoints overrides every host the client talks to, streams included.
+func WithEndpoints(endpoints Endpoints) ClientOpts {
+	return func(o *clientoptions) {
+		o.endpoints = endpoints
+	}
+}
+
+func newClientOptions(opts []ClientOpts) clientoptions {
 	optsMap := clientoptions{}
 
 	for _, opt := range opts {
@@ -108,17 +230,117 @@ func New(opts ...ClientOpts) (out *Client, err error) {
 		optsMap.httpClient = &http.Client{}
 	}
 
-	player, err := NewPlayer()
-	if err != nil {
-		return
+	if !optsMap.nsigSet {
+		optsMap.nsigCache = defaultNSigCache
+	}
+
+	if optsMap.endpoints.Base == "" {
+		optsMap.endpoints.Base = URLs.YTBase
+	}
+
+	return optsMap
+}
+
+func New(opts ...ClientOpts) (*Client, error) {
+	return NewContext(context.Background(), opts...)
+}
+
+// NewContext creates a client without doing any network I/O. The player is
+// loaded by the first call that needs it, using that call's context.
+func NewContext(ctx context.Context, opts ...ClientOpts) (*Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	optsMap := newClientOptions(opts)
+
+	if _, err := optsMap.endpoints.url("/"); err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
 	}
 
 	return &Client{
-		player:     player,
-		httpClient: optsMap.httpClient,
+		httpClient:  optsMap.httpClient,
+		endpoints:   optsMap.endpoints,
+		playerStore: optsMap.playerStore,
+		nsigCache:   optsMap.nsigCache,
+		limiter:     newRateLimiter(optsMap.rateLimit),
 	}, nil
 }
 
+// playerLoad is a load of the player that callers of getPlayer wait for.
+type playerLoad struct {
+	done   chan struct{} // closed once player or err is set
+	player *Player
+	err    error
+}
+
+// getPlayer returns the client's player, loading it on first use.
+// Concurrent callers share a single load, which runs without the lock so
+// that each of them can give up when its context ends. A failed load is
+// not cached, so the next call tries again.
+func (c *Client) getPlayer(ctx context.Context) (*Player, error) {
+	c.playerMu.Lock()
+	if c.player != nil {
+		defer c.playerMu.Unlock()
+		return c.player, nil
+	}
+
+	load := c.playerLoad
+	if load == nil {
+		load = &playerLoad{done: make(chan struct{})}
+		c.playerLoad = load
+		// the load outlives the caller that started it if others wait
+		go c.loadPlayer(context.WithoutCancel(ctx), load)
+	}
+	c.playerMu.Unlock()
+
+	select {
+	case <-load.done:
+		return load.player, load.err
+	case <-ctx.Done():
+		return nil, &ErrPlayerLoad{Err: ctx.Err()}
+	}
+}
+
+// loadPlayer runs load and keeps its player on success.
+func (c *Client) loadPlayer(ctx context.Context, load *playerLoad) {
+	defer close(load.done)
+
+	visitorData, err := c.getVisitorData(ctx)
+	if err == nil {
+		load.player, err = newPlayer(ctx, c.httpClient, c.endpoints, visitorData, c.playerStore)
+	}
+
+	c.playerMu.Lock()
+	defer c.playerMu.Unlock()
+
+	c.playerLoad = nil
+	if err != nil {
+		load.player, load.err = nil, &ErrPlayerLoad{Err: err}
+		return
+	}
+	c.player = load.player
+}
+
+// getVisitorData returns the client's visitor data, fetching it on first use.
+func (c *Client) getVisitorData(ctx context.Context) (string, error) {
+	c.visitorMu.Lock()
+	defer c.visitorMu.Unlock()
+
+	if c.visitorData != "" {
+		return c.visitorData, nil
+	}
+
+	visitorData, err := getVisitorData(ctx, c.httpClient, c.endpoints)
+	if err != nil {
+		return "", err
+	}
+
+	c.visitorData = visitorData
+
+	return visitorData, nil
+}
+
 func (c *Client) LoadCookies(path string) (err error) {
 	f, err := os.Open("./cookies.txt")
 	if err != nil {
@@ -135,7 +357,7 @@ func (c *Client) LoadCookies(path string) (err error) {
 		log.Fatal(err)
 	}
 
-	u, err := url.Parse(URLs.YTBase)
+	u, err := url.Parse(c.endpoints.Base)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -166,27 +388,105 @@ func (c *Client) GetVideoContext(ctx context.Context, id string, opts ...VideoOp
 		optsMap.client = defaultYoutubeClient
 	}
 
-	client, ok := Clients[optsMap.client]
-	if !ok {
-		return nil, errors.New("invalid client")
+	names := optsMap.fallback
+	if len(names) == 0 {
+		names = []string{optsMap.client}
+	}
+
+	clients := make([]*YoutubeClient, len(names))
+	for i, name := range names {
+		client, ok := Clients[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid client %q", name)
+		}
+		clients[i] = &client
+	}
+
+	v, err := c.fetchVideoFallback(ctx, id, clients, optsMap.merge)
+	if err != nil {
+		return v, err
+	}
+
+	return v, c.prepareFormats(ctx, v, &optsMap)
+}
+
+// fetchVideoFallback fetches the video with the first of clients that is not
+// refused playback and gets formats. With merge, it goes on with the other
+// clients and adds the formats they get which are not in the list yet.
+func (c *Client) fetchVideoFallback(ctx context.Context, id string, clients []*YoutubeClient, merge bool) (*Video, error) {
+	if len(clients) == 1 {
+		return c.fetchVideo(ctx, id, clients[0])
+	}
+
+	var video *Video
+	var errs []error
+
+	for _, client := range clients {
+		v, err := c.fetchVideo(ctx, id, client)
+
+		switch {
+		case err != nil && video != nil:
+			slog.Debug("failed to fetch formats to merge", "client", client.Name, "error", err)
+			continue
+		case err != nil && !isPlayabilityError(err):
+			return v, err
+		case err != nil:
+			slog.Debug("falling back to the next client", "client", client.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", client.Name, err))
+			continue
+		case video == nil:
+			video = v
+		default:
+			video.mergeFormats(v)
+		}
+
+		if !merge {
+			break
+		}
+	}
+
+	if video == nil {
+		return nil, errors.Join(errs...)
+	}
+
+	return video, nil
+}
+
+// isPlayabilityError reports whether another client may succeed where one
+// failed with err.
+func isPlayabilityError(err error) bool {
+	var status *ErrPlayabiltyStatus
+	return errors.Is(err, ErrLoginRequired) ||
+		errors.Is(err, ErrNotPlayableInEmbed) ||
+		errors.Is(err, ErrNoFormats) ||
+		errors.As(err, &status)
+}
+
+// fetchVideo requests the player response for id using client.
+func (c *Client) fetchVideo(ctx context.Context, id string, client *YoutubeClient) (*Video, error) {
+	player, err := c.getPlayer(ctx)
+	if err != nil {
+		return nil, err
 	}
-	data := c.player.generatePlayerParams(id, &client)
 
-	uri, err := url.Parse(URLs.YTBase)
+	data := player.generatePlayerParams(id, client)
+
+	uri, err := c.endpoints.url("/youtubei/v1/player")
 	if err != nil {
 		return nil, err
 	}
-	uri.Path = path.Join(uri.Path, "/youtubei/v1/player")
 
 	if client.APIKey != "" {
 		query := uri.Query()
 		query.Add("key", client.APIKey)
+		uri.RawQuery = query.Encode()
 	}
 
 	ctx = context.WithValue(ctx, contextKey("info"), contextInfo{
-		Self:   c,
-		Client: &client,
-		Player: c.player,
+		Self:        c,
+		Client:      client,
+		Player:      player,
+		VisitorData: player.visitorData,
 	})
 
 	body, err := httpPostBodyBytes(ctx, uri.String(), data)
@@ -196,7 +496,7 @@ func (c *Client) GetVideoContext(ctx context.Context, id string, opts ...VideoOp
 
 	v := &Video{
 		ID:     id,
-		client: &client,
+		client: client,
 	}
 
 	if err = v.parseVideoInfo(body); err == nil {
@@ -204,26 +504,80 @@ func (c *Client) GetVideoContext(ctx context.Context, id string, opts ...VideoOp
 	}
 
 	if errors.Is(err, ErrNotPlayableInEmbed) {
-		uri, err := url.Parse(URLs.YTBase)
+		uri, err := c.endpoints.url("/watch")
 		if err != nil {
 			return nil, err
 		}
 
-		uri.Path = path.Join(uri.Path, "/watch")
 		query := uri.Query()
 		query.Add("v", id)
 		query.Add("bpctr", "9999999999")
 		query.Add("has_verified", "1")
+		uri.RawQuery = query.Encode()
 
 		html, err := httpGetBodyBytes(ctx, uri.String())
 		if err != nil {
 			return nil, err
 		}
 
-		return v, v.parseVideoPage(html)
+		if err := v.parseVideoPage(html); err != nil {
+			return v, err
+		}
+
+		return v, nil
+	}
+
+	return v, err
+}
+
+// prepareFormats applies the format related video options to v.
+func (c *Client) prepareFormats(ctx context.Context, v *Video, opts *videooptions) error {
+	if len(opts.rankers) > 0 {
+		v.Formats.Sort(opts.rankers...)
+	}
+
+	if opts.decipher {
+		return c.decipherFormats(ctx, v)
 	}
 
-	return v, nil
+	return nil
+}
+
+// decipherFormats sets DecipheredURL on every format of v, in parallel.
+func (c *Client) decipherFormats(ctx context.Context, v *Video) error {
+	formats := v.Formats
+	routines := c.getMaxRoutines(len(formats))
+	errs := make([]error, len(formats))
+
+	current := atomic.Uint32{}
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < routines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				index := int(current.Add(1)) - 1
+				if index >= len(formats) {
+					return
+				}
+
+				format := &formats[index]
+				uri, err := c.GetStreamURLContext(ctx, v, format)
+				if err != nil {
+					errs[index] = fmt.Errorf("unable to decipher itag %d: %w", format.ItagNo, err)
+					continue
+				}
+
+				format.DecipheredURL = uri
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
 
 // GetPlaylist fetches playlist metadata
@@ -250,10 +604,16 @@ func (c *Client) GetPlaylistContext(ctx context.Context, uri string, opts ...Vid
 		return nil, errors.New("invalid client")
 	}
 
+	// playlists don't need the player, and browse works without visitor data
+	visitorData, err := c.getVisitorData(ctx)
+	if err != nil {
+		slog.Debug("failed to fetch visitor data", "error", err)
+	}
+
 	cinfo := contextInfo{
-		Self:   c,
-		Player: c.player,
-		Client: &client,
+		Self:        c,
+		Client:      &client,
+		VisitorData: visitorData,
 	}
 
 	ctx = context.WithValue(ctx, contextKey("info"), cinfo)
@@ -262,18 +622,17 @@ func (c *Client) GetPlaylistContext(ctx context.Context, uri string, opts ...Vid
 		return nil, fmt.Errorf("extractPlaylistID failed: %w", err)
 	}
 
-	base_uri, err := url.Parse(URLs.YTBase)
+	base_uri, err := c.endpoints.url("/youtubei/v1/browse")
 	if err != nil {
 		return nil, err
 	}
 
-	base_uri.Path = path.Join(base_uri.Path, "/youtubei/v1/browse")
 	if client.APIKey != "" {
 		query := base_uri.Query()
 		query.Add("key", client.APIKey)
 	}
 
-	data := c.player.prepareInnertubePlaylistData(id, false, &client)
+	data := prepareInnertubePlaylistData(id, false, &client, visitorData)
 	body, err := httpPostBodyBytes(ctx, base_uri.String(), data)
 	if err != nil {
 		return nil, err
@@ -291,7 +650,7 @@ func (c *Client) VideoFromPlaylistEntryContext(ctx context.Context, entry *Playl
 	return c.GetVideoContext(ctx, entry.ID, opts...)
 }
 
-func (p *Player) generateInnertubeContext(client *YoutubeClient) inntertubeContext {
+func generateInnertubeContext(client *YoutubeClient, visitorData string) inntertubeContext {
 	return inntertubeContext{
 		Client: innertubeClient{
 			HL:                "en",
@@ -302,13 +661,13 @@ func (p *Player) generateInnertubeContext(client *YoutubeClient) inntertubeConte
 			ClientVersion:     client.Version,
 			AndroidSDKVersion: client.SDKVersion,
 			UserAgent:         client.UserAgent,
-			VisitorData:       p.visitorData,
+			VisitorData:       visitorData,
 		},
 	}
 }
 
-func (p *Player) prepareInnertubePlaylistData(id string, continuation bool, client *YoutubeClient) innertubeRequest {
-	context := p.generateInnertubeContext(client)
+func prepareInnertubePlaylistData(id string, continuation bool, client *YoutubeClient, visitorData string) innertubeRequest {
+	context := generateInnertubeContext(client, visitorData)
 
 	if continuation {
 		return innertubeRequest{
@@ -328,7 +687,7 @@ func (p *Player) prepareInnertubePlaylistData(id string, continuation bool, clie
 }
 
 func (p *Player) generatePlayerParams(id string, client *YoutubeClient) innertubeRequest {
-	context := p.generateInnertubeContext(client)
+	context := generateInnertubeContext(client, p.visitorData)
 
 	return innertubeRequest{
 		VideoID:        id,
@@ -345,23 +704,19 @@ func (p *Player) generatePlayerParams(id string, client *YoutubeClient) innertub
 	}
 }
 
-func getVisitorData() (out string, err error) {
-	uri, err := url.Parse(URLs.YTBase)
+func getVisitorData(ctx context.Context, httpClient *http.Client, endpoints Endpoints) (out string, err error) {
+	uri, err := endpoints.url("/sw.js_data")
 	if err != nil {
 		return
 	}
-	uri.Path = path.Join(uri.Path, "/sw.js_data")
-	resp, err := http.Get(uri.String())
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+
+	body, err := playerGet(ctx, httpClient, uri.String())
 	if err != nil {
 		return
 	}
 
-	jsonData := body[6:]
+	// strip the XSSI guard
+	jsonData := bytes.TrimSpace(bytes.TrimPrefix(body, []byte(")]}'")))
 
 	var data []interface{}
 	err = json.Unmarshal(jsonData, &data)
@@ -395,72 +750,102 @@ func (c *Client) GetStreamURLContext(ctx context.Context, video *Video, format *
 		return "", ErrNoFormat
 	}
 
-	return c.player.decipher(format.URL, format.Cipher)
+	// a URL deciphered while fetching the video is only good until it expires
+	if format.DecipheredURL != "" && (format.ExpiresAt.IsZero() || time.Now().Before(format.ExpiresAt)) {
+		return format.DecipheredURL, nil
+	}
+
+	player, err := c.getPlayer(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	uri, err := player.decipher(format.URL, format.Cipher, video.formatClient(format), c.nsigCache)
+	if err != nil {
+		return "", err
+	}
+
+	return c.endpoints.streamURL(uri)
 }
 
-func (c *Client) GetStream(video *Video, format *Format) (io.ReadCloser, int64, error) {
-	return c.GetStreamContext(context.Background(), video, format)
+func (c *Client) GetStream(video *Video, format *Format, opts ...DownloadOpts) (io.ReadCloser, int64, error) {
+	return c.GetStreamContext(context.Background(), video, format, opts...)
 }
 
 // GetStreamContext returns the stream and the total size for a specific format with a context.
-func (c *Client) GetStreamContext(ctx context.Context, video *Video, format *Format) (io.ReadCloser, int64, error) {
+func (c *Client) GetStreamContext(ctx context.Context, video *Video, format *Format, opts ...DownloadOpts) (io.ReadCloser, int64, error) {
+	if format == nil {
+		return nil, 0, ErrNoFormat
+	}
+
+	optsMap := downloadoptions{}
+	for _, opt := range opts {
+		opt(&optsMap)
+	}
+
 	cinfo := contextInfo{
 		Self:   c,
 		Player: nil,
-		Client: video.client,
+		Client: video.formatClient(format),
 	}
 
 	ctx = context.WithValue(ctx, contextKey("info"), cinfo)
-	url, err := c.GetStreamURL(video, format)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
+	src := c.newStreamSource(video, format)
+	if _, _, err := src.get(ctx); err != nil {
 		return nil, 0, err
 	}
 
 	r, w := io.Pipe()
 	contentLength := format.ContentLength
+	tracker := newProgressTracker(optsMap.observers, contentLength)
 
 	if contentLength == 0 {
 		// some videos don't have length information
-		contentLength = c.downloadOnce(ctx, req, w, format)
+		contentLength = c.downloadOnce(ctx, src, w, format, tracker)
 	} else {
 		// we have length information, let's download by chunks!
-		c.downloadChunked(ctx, req, w, format)
+		c.downloadChunked(ctx, src, w, getChunks(contentLength, c.getChunkSize()), tracker)
 	}
 
 	return r, contentLength, nil
 }
 
-func (c *Client) downloadOnce(ctx context.Context, req *http.Request, w *io.PipeWriter, _ *Format) int64 {
-	resp, err := httpDo(ctx, req)
+func (c *Client) downloadOnce(ctx context.Context, src *streamSource, w *io.PipeWriter, _ *Format, tracker *progressTracker) int64 {
+	resp, err := src.do(ctx, nil)
 	if err != nil {
 		w.CloseWithError(err) //nolint:errcheck
 		return 0
 	}
 
+	contentLength := resp.Header.Get("Content-Length")
+	length, _ := strconv.ParseInt(contentLength, 10, 64)
+	tracker.setTotal(length)
+
 	go func() {
 		defer resp.Body.Close()
-		_, err := io.Copy(w, resp.Body)
+		body, _ := tracker.reader(c.limiter.reader(ctx, resp.Body))
+		_, err := io.Copy(w, body)
 		if err == nil {
 			w.Close()
 		} else {
 			w.CloseWithError(err) //nolint:errcheck
 		}
+		tracker.report(true)
 	}()
 
-	contentLength := resp.Header.Get("Content-Length")
-	length, _ := strconv.ParseInt(contentLength, 10, 64)
-
 	return length
 }
 
-func (c *Client) downloadChunked(ctx context.Context, req *http.Request, w *io.PipeWriter, format *Format) {
-	chunks := getChunks(format.ContentLength, c.getChunkSize())
+func (c *Client) downloadChunked(ctx context.Context, src *streamSource, w *io.PipeWriter, chunks []chunk, tracker *progressTracker) {
+	if len(chunks) == 0 {
+		w.Close()
+		return
+	}
+
 	maxRoutines := c.getMaxRoutines(len(chunks))
+	maxBuffered := c.getMaxBufferedChunks(maxRoutines)
+	maxRoutines = min(maxRoutines, maxBuffered)
+	buffers := newChunkBuffers(c.getChunkSize())
 
 	cancelCtx, cancel := context.WithCancel(ctx)
 	abort := func(err error) {
@@ -468,21 +853,36 @@ func (c *Client) downloadChunked(ctx context.Context, req *http.Request, w *io.P
 		cancel()
 	}
 
+	// A slot is taken before a chunk is claimed and given back once the
+	// chunk is written to the pipe, so that at most maxBuffered chunks are
+	// downloading or waiting for the reader. Chunks are claimed in order,
+	// hence the chunk the reader waits for always holds a slot.
+	slots := make(chan struct{}, maxBuffered)
+
 	currentChunk := atomic.Uint32{}
 	for i := 0; i < maxRoutines; i++ {
 		go func() {
 			for {
+				select {
+				case <-cancelCtx.Done():
+					return
+				case slots <- struct{}{}:
+				}
+
 				chunkIndex := int(currentChunk.Add(1)) - 1
 				if chunkIndex >= len(chunks) {
 					// no more chunks
+					<-slots
 					return
 				}
 
 				chunk := &chunks[chunkIndex]
-				err := c.downloadChunk(ctx, req.Clone(cancelCtx), chunk)
+				buf := buffers.get(chunk.end - chunk.start + 1)
+				err := c.downloadChunk(cancelCtx, src, chunk, buf, tracker)
 				close(chunk.data)
 
 				if err != nil {
+					buffers.put(buf)
 					abort(err)
 					return
 				}
@@ -497,49 +897,105 @@ func (c *Client) downloadChunked(ctx context.Context, req *http.Request, w *io.P
 			case <-cancelCtx.Done():
 				abort(context.Canceled)
 				return
-			case data := <-chunks[i].data:
-				_, err := io.Copy(w, bytes.NewBuffer(data))
+			case data, ok := <-chunks[i].data:
+				if !ok {
+					// the download of the chunk failed and aborted
+					return
+				}
+
+				_, err := w.Write(data)
+				buffers.put(data)
+				<-slots
+
 				if err != nil {
 					abort(err)
+					return
 				}
 			}
 		}
 
 		// everything succeeded
 		w.Close()
+		cancel()
 	}()
 }
 
-func (c *Client) downloadChunk(ctx context.Context, req *http.Request, chunk *chunk) error {
-	q := req.URL.Query()
-	q.Set("range", fmt.Sprintf("%d-%d", chunk.start, chunk.end))
-	req.URL.RawQuery = q.Encode()
-
-	resp, err := httpDo(ctx, req)
-	if err != nil {
-		return err
+// downloadChunk downloads a chunk, requesting its range again if the
+// transfer breaks off. Failed requests are already retried by httpDo.
+// The chunk is read into buf, which has to hold at least the chunk's length,
+// and sent on chunk.data.
+func (c *Client) downloadChunk(ctx context.Context, src *streamSource, chunk *chunk, buf []byte, tracker *progressTracker) error {
+	policy := c.getRetryPolicy()
+	tracker.chunkStarted(chunk)
+
+	// tries counts every request made for the chunk, including the
+	// ones retried by httpDo, for the observer events.
+	tries := 1
+	if tracker != nil {
+		ctx = withRetryHook(ctx, func(_ int, err error) {
+			tracker.chunkRetry(chunk, tries, 0, err)
+			tries++
+		})
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return ErrUnexpectedStatusCode(resp.StatusCode)
+	for attempt := 1; ; attempt++ {
+		resp, err := src.do(ctx, func(q url.Values) {
+			q.Set("range", fmt.Sprintf("%d-%d", chunk.start, chunk.end))
+		})
+		if err != nil {
+			tracker.chunkFailed(chunk, tries, 0, err)
+			return err
+		}
+
+		body, received := tracker.reader(c.limiter.reader(ctx, resp.Body))
+		data, err := readChunk(body, chunk, buf)
+		resp.Body.Close()
+
+		if err == nil {
+			tracker.chunkCompleted(chunk, tries)
+			chunk.data <- data
+			return nil
+		}
+
+		if attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			tracker.chunkFailed(chunk, tries, *received, err)
+			return err
+		}
+
+		slog.Debug("retrying chunk", "start", chunk.start, "end", chunk.end, "attempt", attempt, "error", err)
+		tracker.chunkRetry(chunk, tries, *received, err)
+
+		if !policy.wait(ctx, attempt, 0) {
+			tracker.chunkFailed(chunk, tries, 0, err)
+			return err
+		}
+		tries++
 	}
+}
 
+func readChunk(r io.Reader, chunk *chunk, buf []byte) ([]byte, error) {
 	expected := int(chunk.end-chunk.start) + 1
-	data, err := io.ReadAll(resp.Body)
-	n := len(data)
-
-	if err != nil {
-		return err
+	if len(buf) < expected {
+		return nil, fmt.Errorf("chunk at offset %d does not fit its buffer: expected=%d buffer=%d", chunk.start, expected, len(buf))
 	}
 
-	if n != expected {
-		return fmt.Errorf("chunk at offset %d has invalid size: expected=%d actual=%d", chunk.start, expected, n)
+	n, err := io.ReadFull(r, buf[:expected])
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return nil, fmt.Errorf("chunk at offset %d has invalid size: expected=%d actual=%d: %w", chunk.start, expected, n, io.ErrUnexpectedEOF)
+	} else if err != nil {
+		return nil, err
 	}
 
-	chunk.data <- data
+	// the server must not send more than requested
+	extra, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return nil, err
+	}
+	if extra > 0 {
+		return nil, fmt.Errorf("chunk at offset %d has invalid size: expected=%d actual=%d", chunk.start, expected, int64(n)+extra)
+	}
 
-	return nil
+	return buf[:expected], nil
 }
 
 const (
@@ -556,6 +1012,16 @@ func (c *Client) getChunkSize() int64 {
 	return Size10Mb
 }
 
+// getMaxBufferedChunks returns how many chunks of a download may be held in
+// memory at once. Without MaxBufferedBytes, each routine holds one chunk.
+func (c *Client) getMaxBufferedChunks(routines int) int {
+	if c.MaxBufferedBytes > 0 {
+		return int(max(c.MaxBufferedBytes/c.getChunkSize(), 1))
+	}
+
+	return max(routines, 1)
+}
+
 func (c *Client) getMaxRoutines(limit int) int {
 	routines := 10
 
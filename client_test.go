@@ -0,0 +1,775 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/steino/youtubedl/youtubedltest"
+)
+
+const (
+	fixtureVideoID    = "fixture0001"
+	fixturePlaylistID = "PLfixture00000000001"
+
+	// fixtureMediaID has synthetic fragmented MP4 and WebM streams
+	fixtureMediaID = "fixture0002"
+
+	// fixtureAgeGatedID requires a login with WEB, gets no formats with
+	// MWEB, and is played by IOS and TV_EMBEDDED with different formats
+	fixtureAgeGatedID = "fixture0003"
+
+	// fixtureWatchPageID cannot be played in embeds, so it is read from its
+	// watch page
+	fixtureWatchPageID = "fixture0004"
+
+	// fixtureBrokenDataID is fixtureWatchPageID with an ytInitialData that
+	// does not decode
+	fixtureBrokenDataID = "fixture0005"
+)
+
+// newTestClient returns a Client talking to a youtubedltest.Server that
+// replays testdata. With youtubedltest.RecordEnv set, it talks to the live
+// service instead and records the responses into testdata.
+func newTestClient(t testing.TB, opts ...ClientOpts) *Client {
+	t.Helper()
+
+	client, err := New(append(testClientOpts(t), opts...)...)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	return client
+}
+
+// testClientOpts returns the options of newTestClient, for the other
+// constructors taking ClientOpts.
+func testClientOpts(t testing.TB) []ClientOpts {
+	t.Helper()
+
+	if youtubedltest.Recording() {
+		recorder := &youtubedltest.Recorder{Dir: "testdata"}
+		return []ClientOpts{WithHTTPClient(&http.Client{Transport: recorder})}
+	}
+
+	srv := youtubedltest.NewServer("testdata")
+	t.Cleanup(srv.Close)
+
+	return []ClientOpts{
+		WithHTTPClient(srv.Client()),
+		WithEndpoints(Endpoints{Base: srv.URL, Stream: srv.URL, Image: srv.URL}),
+	}
+}
+
+func TestGetVideo(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	if video.Title != "Fixture Video" {
+		t.Errorf("video.Title = %q", video.Title)
+	}
+	if video.Author != "Fixture Channel" {
+		t.Errorf("video.Author = %q", video.Author)
+	}
+	if video.ChannelHandle != "@fixturechannel" {
+		t.Errorf("video.ChannelHandle = %q", video.ChannelHandle)
+	}
+	if video.Duration != 212*time.Second {
+		t.Errorf("video.Duration = %v", video.Duration)
+	}
+	if video.Views != 1234567 {
+		t.Errorf("video.Views = %d", video.Views)
+	}
+	if want := time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC); !video.PublishDate.Equal(want) {
+		t.Errorf("video.PublishDate = %v", video.PublishDate)
+	}
+	if want := time.Date(2023, 11, 13, 0, 0, 0, 0, time.UTC); !video.UploadDate.Equal(want) {
+		t.Errorf("video.UploadDate = %v", video.UploadDate)
+	}
+	if strings.Join(video.Keywords, ",") != "fixture,test" {
+		t.Errorf("video.Keywords = %q", video.Keywords)
+	}
+	if video.Category != "Music" {
+		t.Errorf("video.Category = %q", video.Category)
+	}
+	if video.AverageRating != 4.5 {
+		t.Errorf("video.AverageRating = %v", video.AverageRating)
+	}
+	if !video.IsFamilySafe || video.IsLiveContent || video.IsPrivate || video.IsUnlisted {
+		t.Errorf("flags: family safe %v, live %v, private %v, unlisted %v",
+			video.IsFamilySafe, video.IsLiveContent, video.IsPrivate, video.IsUnlisted)
+	}
+	if len(video.AvailableCountries) != 3 || !video.Available("NO") || !video.Available("gb") || video.Available("FR") {
+		t.Errorf("video.AvailableCountries = %v", video.AvailableCountries)
+	}
+	if len(video.Formats) != 3 {
+		t.Fatalf("len(video.Formats) = %d", len(video.Formats))
+	}
+	if video.Formats[0].ItagNo != 137 {
+		t.Errorf("formats not sorted by bitrate, first itag = %d", video.Formats[0].ItagNo)
+	}
+}
+
+func TestVideoMetadata(t *testing.T) {
+	body := `{
+		"playabilityStatus": {"status": "OK"},
+		"streamingData": {"formats": [{"itag": 18, "url": "https://example.com/videoplayback"}]},
+		"videoDetails": {"keywords": ["live"], "averageRating": 3.25, "isPrivate": true, "isLiveContent": true},
+		"microformat": {"playerMicroformatRenderer": {
+			"isUnlisted": true,
+			"category": "Gaming",
+			"availableCountries": [],
+			"publishDate": "2024-03-05T09:30:00-08:00",
+			"uploadDate": "2024-03-04T23:00:00-08:00"
+		}}
+	}`
+
+	v := &Video{}
+	if err := v.parseVideoInfo([]byte(body)); err != nil {
+		t.Fatalf("parseVideoInfo failed: %v", err)
+	}
+
+	if want := time.Date(2024, 3, 5, 17, 30, 0, 0, time.UTC); !v.PublishDate.Equal(want) {
+		t.Errorf("PublishDate = %v, want %v", v.PublishDate, want)
+	}
+	if want := time.Date(2024, 3, 5, 7, 0, 0, 0, time.UTC); !v.UploadDate.Equal(want) {
+		t.Errorf("UploadDate = %v, want %v", v.UploadDate, want)
+	}
+	if v.Category != "Gaming" || v.AverageRating != 3.25 || len(v.Keywords) != 1 {
+		t.Errorf("Category %q, AverageRating %v, Keywords %q", v.Category, v.AverageRating, v.Keywords)
+	}
+	if v.IsFamilySafe || !v.IsLiveContent || !v.IsPrivate || !v.IsUnlisted {
+		t.Errorf("flags: family safe %v, live %v, private %v, unlisted %v",
+			v.IsFamilySafe, v.IsLiveContent, v.IsPrivate, v.IsUnlisted)
+	}
+
+	// an empty list is available nowhere, a missing one everywhere
+	if v.AvailableCountries == nil || v.Available("US") {
+		t.Errorf("empty country list: AvailableCountries = %v, Available(US) = %v", v.AvailableCountries, v.Available("US"))
+	}
+	if v := (&Video{}); !v.Available("US") {
+		t.Error("no country list: Available(US) = false")
+	}
+}
+
+func TestGetPlaylist(t *testing.T) {
+	client := newTestClient(t)
+
+	playlist, err := client.GetPlaylist("https://www.youtube.com/playlist?list=" + fixturePlaylistID)
+	if err != nil {
+		t.Fatalf("GetPlaylist failed: %v", err)
+	}
+
+	if playlist.Title != "Fixture Playlist" {
+		t.Errorf("playlist.Title = %q", playlist.Title)
+	}
+	if playlist.Author != "Fixture Channel" {
+		t.Errorf("playlist.Author = %q", playlist.Author)
+	}
+
+	// the third entry comes from the continuation
+	if len(playlist.Videos) != 3 {
+		t.Fatalf("len(playlist.Videos) = %d", len(playlist.Videos))
+	}
+
+	entry := playlist.Videos[2]
+	if entry.ID != "fixvid00003" || entry.Title != "Third" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if entry.Duration == nil || *entry.Duration != 183*time.Second {
+		t.Errorf("entry.Duration = %v", entry.Duration)
+	}
+}
+
+func TestGetStreamURL(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	tests := []struct {
+		itag int
+		n    string
+		sig  string
+	}{
+		{itag: 18, n: "hgfedcbaxyz"},
+		{itag: 137, n: "iuytrewqxyz", sig: "IMLKJNHGFEDCBA"},
+	}
+
+	for _, tt := range tests {
+		format := &video.Formats.Itag(tt.itag)[0]
+
+		stream, err := client.GetStreamURL(video, format)
+		if err != nil {
+			t.Fatalf("itag %d: GetStreamURL failed: %v", tt.itag, err)
+		}
+
+		uri, err := url.Parse(stream)
+		if err != nil {
+			t.Fatalf("itag %d: invalid URL: %v", tt.itag, err)
+		}
+
+		query := uri.Query()
+		if got := query.Get("n"); got != tt.n {
+			t.Errorf("itag %d: n = %q, want %q", tt.itag, got, tt.n)
+		}
+		if got := query.Get("sig"); got != tt.sig {
+			t.Errorf("itag %d: sig = %q, want %q", tt.itag, got, tt.sig)
+		}
+		if got := query.Get("cver"); got != Clients["WEB"].Version {
+			t.Errorf("itag %d: cver = %q", tt.itag, got)
+		}
+	}
+}
+
+func TestGetStreamChunked(t *testing.T) {
+	client := newTestClient(t)
+	client.ChunkSize = 7 * Size1Kb
+	client.MaxRoutines = 4
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	for _, itag := range []int{18, 137, 140} {
+		format := &video.Formats.Itag(itag)[0]
+
+		stream, size, err := client.GetStreamContext(context.Background(), video, format)
+		if err != nil {
+			t.Fatalf("itag %d: GetStream failed: %v", itag, err)
+		}
+
+		data, err := io.ReadAll(stream)
+		stream.Close()
+		if err != nil {
+			t.Fatalf("itag %d: read failed: %v", itag, err)
+		}
+
+		want, err := os.ReadFile("testdata/videoplayback/o-fixture." + strconv.Itoa(itag))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if size != int64(len(want)) {
+			t.Errorf("itag %d: size = %d, want %d", itag, size, len(want))
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("itag %d: stream content differs from fixture", itag)
+		}
+	}
+}
+
+func TestGetStreamNoFormat(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	if _, _, err := client.GetStream(video, nil); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("GetStream(nil) err = %v, want ErrNoFormat", err)
+	}
+}
+
+func TestLazyPlayer(t *testing.T) {
+	fixtures := youtubedltest.NewHandler("testdata")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/iframe_api" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fixtures.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	client, err := NewContext(context.Background(), WithHTTPClient(srv.Client()), WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatalf("NewContext failed: %v", err)
+	}
+
+	if _, err := client.GetPlaylist(fixturePlaylistID); err != nil {
+		t.Errorf("GetPlaylist failed without a player: %v", err)
+	}
+
+	_, err = client.GetVideo(fixtureVideoID)
+
+	var loadErr *ErrPlayerLoad
+	if !errors.As(err, &loadErr) {
+		t.Fatalf("GetVideo error = %v, want ErrPlayerLoad", err)
+	}
+	if !errors.Is(err, ErrUnexpectedStatusCode(http.StatusServiceUnavailable)) {
+		t.Errorf("GetVideo error = %v, want status 503", err)
+	}
+}
+
+func TestPlayerLoadCanceled(t *testing.T) {
+	release := make(chan struct{})
+	var iframeRequests atomic.Int32
+	fixtures := youtubedltest.NewHandler("testdata")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/iframe_api" {
+			iframeRequests.Add(1)
+			<-release
+		}
+		fixtures.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	client, err := New(WithHTTPClient(srv.Client()), WithBaseURL(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a caller waiting for the stalled load gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.getPlayer(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("getPlayer error = %v, want context.DeadlineExceeded", err)
+	}
+
+	// the others share the load still in flight
+	var wg sync.WaitGroup
+	players := make([]*Player, 3)
+	for i := range players {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			players[i], _ = client.getPlayer(context.Background())
+		}()
+	}
+	close(release)
+	wg.Wait()
+
+	for i, p := range players {
+		if p == nil || p != players[0] {
+			t.Errorf("player %d = %p, want the shared %p", i, p, players[0])
+		}
+	}
+	if n := iframeRequests.Load(); n != 1 {
+		t.Errorf("player loaded %d times, want 1", n)
+	}
+}
+
+func TestWithDecipheredURLs(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureVideoID, WithDecipheredURLs())
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	for _, format := range video.Formats {
+		if format.DecipheredURL == "" {
+			t.Errorf("itag %d: DecipheredURL not set", format.ItagNo)
+			continue
+		}
+
+		uri, err := url.Parse(format.DecipheredURL)
+		if err != nil {
+			t.Fatalf("itag %d: invalid URL: %v", format.ItagNo, err)
+		}
+		if !strings.HasSuffix(uri.Query().Get("n"), "xyz") {
+			t.Errorf("itag %d: n not deciphered in %s", format.ItagNo, format.DecipheredURL)
+		}
+
+		if until := time.Until(format.ExpiresAt); until < 5*time.Hour || until > 6*time.Hour {
+			t.Errorf("itag %d: ExpiresAt = %v", format.ItagNo, format.ExpiresAt)
+		}
+
+		if got, err := client.GetStreamURL(video, &format); err != nil || got != format.DecipheredURL {
+			t.Errorf("itag %d: GetStreamURL = %q, %v, want DecipheredURL", format.ItagNo, got, err)
+		}
+
+		// expired URLs are deciphered again
+		format.DecipheredURL = "https://expired.example.com/videoplayback"
+		format.ExpiresAt = time.Now().Add(-time.Minute)
+		if got, err := client.GetStreamURL(video, &format); err != nil || got == format.DecipheredURL {
+			t.Errorf("itag %d: GetStreamURL of an expired format = %q, %v", format.ItagNo, got, err)
+		}
+	}
+}
+
+func TestClientFallback(t *testing.T) {
+	client := newTestClient(t)
+
+	if _, err := client.GetVideo(fixtureAgeGatedID); !errors.Is(err, ErrLoginRequired) {
+		t.Fatalf("WEB only: err = %v, want ErrLoginRequired", err)
+	}
+
+	video, err := client.GetVideo(fixtureAgeGatedID, WithClientFallback("WEB", "MWEB", "IOS", "TV_EMBEDDED"))
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+	if video.Title != "Fixture Age Restricted" {
+		t.Errorf("video.Title = %q", video.Title)
+	}
+	if got := rankedItags(video.Formats); got != "136 140" {
+		t.Errorf("formats = %s, want those of IOS", got)
+	}
+
+	for i := range video.Formats {
+		format := &video.Formats[i]
+		if format.Client == nil || format.Client.Name != Clients["IOS"].Name {
+			t.Errorf("itag %d: Client = %v, want IOS", format.ItagNo, format.Client)
+		}
+	}
+
+	stream, err := client.GetStreamURL(video, &video.Formats[0])
+	if err != nil {
+		t.Fatalf("GetStreamURL failed: %v", err)
+	}
+	uri, _ := url.Parse(stream)
+	if got := uri.Query().Get("cver"); got != Clients["IOS"].Version {
+		t.Errorf("cver = %q, want the IOS version", got)
+	}
+}
+
+func TestClientFallbackMerge(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureAgeGatedID,
+		WithClientFallback("WEB", "MWEB", "IOS", "TV_EMBEDDED"),
+		WithMergedFormats(),
+	)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	want := map[int]string{136: "IOS", 140: "IOS", 247: "TV_EMBEDDED", 251: "TV_EMBEDDED"}
+	if got := rankedItags(video.Formats); got != "136 140 247 251" {
+		t.Errorf("formats = %s, want 136 140 247 251", got)
+	}
+
+	for i := range video.Formats {
+		format := &video.Formats[i]
+		source := Clients[want[format.ItagNo]]
+		if format.Client == nil || format.Client.Name != source.Name {
+			t.Errorf("itag %d: Client = %v, want %s", format.ItagNo, format.Client, want[format.ItagNo])
+			continue
+		}
+
+		stream, err := client.GetStreamURL(video, format)
+		if err != nil {
+			t.Fatalf("itag %d: GetStreamURL failed: %v", format.ItagNo, err)
+		}
+		uri, _ := url.Parse(stream)
+		if got := uri.Query().Get("cver"); got != source.Version {
+			t.Errorf("itag %d: cver = %q, want %q", format.ItagNo, got, source.Version)
+		}
+	}
+
+	format := &video.Formats.Itag(247)[0]
+	r, _, err := client.GetStream(video, format)
+	if err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading the stream failed: %v", err)
+	}
+	want247, err := os.ReadFile("testdata/videoplayback/o-fixture2.247")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want247) {
+		t.Errorf("stream of itag 247 differs from the fixture")
+	}
+}
+
+func TestClientFallbackErrors(t *testing.T) {
+	client := newTestClient(t)
+
+	_, err := client.GetVideo(fixtureAgeGatedID, WithClientFallback("WEB", "MWEB"))
+	if !errors.Is(err, ErrLoginRequired) || !errors.Is(err, ErrNoFormats) {
+		t.Errorf("err = %v, want ErrLoginRequired and ErrNoFormats", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "MWEB: ") {
+		t.Errorf("err = %v, does not name the client", err)
+	}
+
+	if _, err := client.GetVideo(fixtureAgeGatedID, WithClientFallback("WEB", "NOKIA")); err == nil || !strings.Contains(err.Error(), "NOKIA") {
+		t.Errorf("unknown client: err = %v", err)
+	}
+}
+
+func TestGetStreamRefresh(t *testing.T) {
+	var playerRequests, streamRequests atomic.Int32
+
+	fixtures := youtubedltest.NewHandler("testdata")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/youtubei/v1/player":
+			playerRequests.Add(1)
+		case "/videoplayback":
+			// the URLs of the first player response expire after two chunks
+			if streamRequests.Add(1) > 2 && playerRequests.Load() == 1 {
+				http.Error(w, "expired", http.StatusForbidden)
+				return
+			}
+		}
+		fixtures.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	client, err := New(WithHTTPClient(srv.Client()), WithEndpoints(Endpoints{Base: srv.URL, Stream: srv.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ChunkSize = 10 * Size1Kb
+	client.MaxRoutines = 2
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+	fetchedAt := video.FetchedAt
+
+	format := &video.Formats.Itag(137)[0]
+	original := *format
+	stream, _, err := client.GetStream(video, format)
+	if err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	want, _ := os.ReadFile("testdata/videoplayback/o-fixture.137")
+	if !bytes.Equal(data, want) {
+		t.Errorf("stream content differs from fixture")
+	}
+	if n := playerRequests.Load(); n != 2 {
+		t.Errorf("player requested %d times, want 2", n)
+	}
+
+	// the refreshed URL stays in the stream, the caller's values are untouched
+	if !video.FetchedAt.Equal(fetchedAt) {
+		t.Errorf("video.FetchedAt changed")
+	}
+	if *format != original {
+		t.Errorf("format changed by the refresh")
+	}
+
+	// an expired URL is resolved again before downloading
+	expired := time.Now().Add(-time.Minute)
+	format.ExpiresAt = expired
+	stream, _, err = client.GetStream(video, format)
+	if err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+	io.Copy(io.Discard, stream) //nolint:errcheck
+
+	if n := playerRequests.Load(); n != 3 {
+		t.Errorf("player requested %d times, want 3", n)
+	}
+	if !format.ExpiresAt.Equal(expired) {
+		t.Errorf("format.ExpiresAt = %v, want it untouched", format.ExpiresAt)
+	}
+}
+
+func TestGetStreamBoundedBuffer(t *testing.T) {
+	var chunkRequests, chunksServed atomic.Int32
+
+	fixtures := youtubedltest.NewHandler("testdata")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/videoplayback" {
+			fixtures.ServeHTTP(w, r)
+			return
+		}
+		chunkRequests.Add(1)
+		fixtures.ServeHTTP(w, r)
+		chunksServed.Add(1)
+	}))
+	defer srv.Close()
+
+	client, err := New(WithHTTPClient(srv.Client()), WithEndpoints(Endpoints{Base: srv.URL, Stream: srv.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ChunkSize = 10 * Size1Kb
+	client.MaxRoutines = 8
+	client.MaxBufferedBytes = 2 * client.ChunkSize
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	stream, _, err := client.GetStream(video, &video.Formats.Itag(137)[0])
+	if err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	// nothing is read, so the download stops once its buffer is full: wait
+	// for the buffered chunks, then for the request count to settle
+	deadline := time.Now().Add(5 * time.Second)
+	for chunksServed.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d chunks served before the deadline, want 2", chunksServed.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	n := chunkRequests.Load()
+	for stable := 0; stable < 5; {
+		time.Sleep(10 * time.Millisecond)
+		if m := chunkRequests.Load(); m != n {
+			n, stable = m, 0
+		} else {
+			stable++
+		}
+	}
+	if n != 2 {
+		t.Errorf("%d chunks requested before reading, want 2", n)
+	}
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	want, _ := os.ReadFile("testdata/videoplayback/o-fixture.137")
+	if !bytes.Equal(data, want) {
+		t.Errorf("stream content differs from fixture")
+	}
+}
+
+func TestReadChunk(t *testing.T) {
+	c := &chunk{start: 100, end: 109}
+	buf := make([]byte, 16)
+
+	data, err := readChunk(strings.NewReader("0123456789"), c, buf)
+	if err != nil || string(data) != "0123456789" {
+		t.Errorf("readChunk = %q, %v", data, err)
+	}
+
+	if _, err := readChunk(strings.NewReader("012345"), c, buf); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("short chunk: err = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	if _, err := readChunk(strings.NewReader("0123456789AB"), c, buf); err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("long chunk: err = %v", err)
+	}
+
+	if _, err := readChunk(strings.NewReader("0123456789"), c, buf[:5]); err == nil {
+		t.Errorf("small buffer: err = nil")
+	}
+}
+
+// BenchmarkGetStreamMemory downloads streams of growing size from a
+// synthetic server and reports the peak heap in use, which is bounded by
+// MaxBufferedBytes and does not depend on the stream size.
+func BenchmarkGetStreamMemory(b *testing.B) {
+	fixtures := youtubedltest.NewServer("testdata")
+	defer fixtures.Close()
+
+	zeros := make([]byte, 32*Size1Kb)
+	streams := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		if _, err := fmt.Sscanf(r.URL.Query().Get("range"), "%d-%d", &start, &end); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		io.CopyBuffer(w, io.LimitReader(zeroReader{}, end-start+1), zeros) //nolint:errcheck
+	}))
+	defer streams.Close()
+
+	client, err := New(WithEndpoints(Endpoints{Base: fixtures.URL, Stream: streams.URL}))
+	if err != nil {
+		b.Fatal(err)
+	}
+	client.ChunkSize = Size1Mb
+	client.MaxRoutines = 4
+	client.MaxBufferedBytes = 8 * Size1Mb
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		b.Fatalf("GetVideo failed: %v", err)
+	}
+
+	for _, size := range []int64{16 * Size1Mb, 64 * Size1Mb, 256 * Size1Mb, 1024 * Size1Mb} {
+		b.Run(fmt.Sprintf("%dMB", size/Size1Mb), func(b *testing.B) {
+			format := video.Formats.Itag(140)[0]
+			format.ContentLength = size
+			b.SetBytes(size)
+
+			var peak atomic.Uint64
+			for range b.N {
+				runtime.GC()
+				stop := sampleHeapInUse(&peak)
+
+				stream, _, err := client.GetStream(video, &format)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, stream); err != nil {
+					b.Fatal(err)
+				}
+				stream.Close()
+
+				stop()
+			}
+
+			b.ReportMetric(float64(peak.Load())/Size1Mb, "peak-heap-MB")
+		})
+	}
+}
+
+// sampleHeapInUse records the peak of the heap in use until stop is called.
+func sampleHeapInUse(peak *atomic.Uint64) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+
+		var stats runtime.MemStats
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > peak.Load() {
+				peak.Store(stats.HeapInuse)
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,185 @@
+package youtubedl
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// MediaKind tells which streams a format holds.
+type MediaKind int
+
+const (
+	MediaUnknown MediaKind = iota
+	MediaVideo             // video only
+	MediaAudio             // audio only
+	MediaMuxed             // video and audio
+)
+
+func (k MediaKind) String() string {
+	switch k {
+	case MediaVideo:
+		return "video"
+	case MediaAudio:
+		return "audio"
+	case MediaMuxed:
+		return "muxed"
+	}
+
+	return "unknown"
+}
+
+// Codec is a codec from the codecs parameter of a MIME type.
+type Codec struct {
+	// Name is the codec as written in the MIME type, such as avc1.640028.
+	Name string
+
+	// Family is the codec without its parameters: avc1, vp9, av01, mp4a,
+	// opus and so on. vp09 is reported as vp9.
+	Family string
+
+	// Profile and Level are empty when unknown. Profiles are named for
+	// avc1 and mp4a (High, AAC-LC) and numbered for vp9 and av01.
+	Profile string
+	Level   string
+
+	// BitDepth is zero when unknown.
+	BitDepth int
+
+	// HDR is set for the PQ and HLG transfer functions.
+	HDR bool
+}
+
+// IsVideo reports whether the codec is a video codec.
+func (c *Codec) IsVideo() bool {
+	switch c.Family {
+	case "avc1", "avc3", "hev1", "hvc1", "vp8", "vp9", "av01", "mp4v":
+		return true
+	}
+
+	return false
+}
+
+// IsAudio reports whether the codec is an audio codec.
+func (c *Codec) IsAudio() bool {
+	return c.Family != "" && !c.IsVideo()
+}
+
+func (c *Codec) String() string {
+	return c.Name
+}
+
+// avcProfiles names the profile_idc values of H.264.
+var avcProfiles = map[int64]string{
+	66:  "Baseline",
+	77:  "Main",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	122: "High 4:2:2",
+	244: "High 4:4:4",
+}
+
+// aacProfiles names the MPEG-4 audio object types.
+var aacProfiles = map[string]string{
+	"1":  "AAC-Main",
+	"2":  "AAC-LC",
+	"5":  "HE-AAC",
+	"29": "HE-AACv2",
+	"34": "MP3",
+}
+
+// ParseCodec parses a codec string as found in the codecs parameter of a
+// MIME type, following RFC 6381 and the VP9 and AV1 codec string formats.
+func ParseCodec(s string) Codec {
+	s = strings.TrimSpace(s)
+	fields := strings.Split(s, ".")
+
+	c := Codec{Name: s, Family: fields[0]}
+	field := func(i int) string {
+		if i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+
+	switch c.Family {
+	case "avc1", "avc3":
+		// profile_idc, constraint flags and level_idc in hex
+		if p := field(1); len(p) == 6 {
+			profile, _ := strconv.ParseInt(p[:2], 16, 64)
+			level, _ := strconv.ParseInt(p[4:], 16, 64)
+			c.Profile = avcProfiles[profile]
+			if c.Profile == "" {
+				c.Profile = strconv.FormatInt(profile, 10)
+			}
+			c.Level = strconv.FormatInt(level/10, 10) + "." + strconv.FormatInt(level%10, 10)
+		}
+
+	case "vp9", "vp09":
+		// vp09.<profile>.<level>.<bit depth>[.<chroma>.<primaries>.<transfer>...]
+		c.Family = "vp9"
+		if len(fields) >= 4 {
+			c.Profile = strings.TrimLeft(field(1), "0")
+			if c.Profile == "" {
+				c.Profile = "0"
+			}
+			if level, err := strconv.Atoi(field(2)); err == nil {
+				c.Level = strconv.Itoa(level/10) + "." + strconv.Itoa(level%10)
+			}
+			c.BitDepth, _ = strconv.Atoi(field(3))
+			c.HDR = hdrTransfer(field(6))
+		}
+
+	case "av01":
+		// av01.<profile>.<level><tier>.<bit depth>[.<monochrome>.<chroma>.<primaries>.<transfer>...]
+		if len(fields) >= 4 {
+			c.Profile = field(1)
+			if level := field(2); len(level) >= 2 {
+				if idx, err := strconv.Atoi(level[:2]); err == nil {
+					c.Level = strconv.Itoa(2+idx>>2) + "." + strconv.Itoa(idx&3)
+				}
+			}
+			c.BitDepth, _ = strconv.Atoi(field(3))
+			c.HDR = hdrTransfer(field(7))
+		}
+
+	case "mp4a":
+		// mp4a.40.<audio object type>
+		if field(1) == "40" {
+			c.Profile = aacProfiles[field(2)]
+		}
+	}
+
+	return c
+}
+
+// hdrTransfer reports whether a transfer characteristics code is PQ or HLG.
+func hdrTransfer(s string) bool {
+	switch s {
+	case "16", "18":
+		return true
+	}
+
+	return false
+}
+
+// parseMimeType returns the media type and the codecs of a MIME type such
+// as video/mp4; codecs="avc1.640028, mp4a.40.2".
+func parseMimeType(s string) (string, []Codec) {
+	mediaType, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		// keep what precedes the parameters
+		mediaType, _, _ = strings.Cut(s, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	}
+
+	var codecs []Codec
+	for _, name := range strings.Split(params["codecs"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			codecs = append(codecs, ParseCodec(name))
+		}
+	}
+
+	return mediaType, codecs
+}
@@ -0,0 +1,325 @@
+package youtubedl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// JournalSuffix is appended to the path given to DownloadToFile to name the
+// journal of completed ranges.
+const JournalSuffix = ".ytdl-journal"
+
+type downloadoptions struct {
+	journalPath string
+	observers   []DownloadObserver
+}
+
+type DownloadOpts func(*downloadoptions)
+
+// WithJournalPath stores the download journal at path instead of next to the file.
+func WithJournalPath(path string) DownloadOpts {
+	return func(o *downloadoptions) {
+		o.journalPath = path
+	}
+}
+
+// downloadJournal records the completed ranges of a download to a file.
+type downloadJournal struct {
+	VideoID       string     `json:"videoId"`
+	Itag          int        `json:"itag"`
+	ContentLength int64      `json:"contentLength"`
+	LastModified  string     `json:"lastModified"`
+	Completed     [][2]int64 `json:"completed"` // sorted, inclusive ranges
+
+	path string
+}
+
+func newDownloadJournal(path string, video *Video, format *Format) *downloadJournal {
+	return &downloadJournal{
+		VideoID:       video.ID,
+		Itag:          format.ItagNo,
+		ContentLength: format.ContentLength,
+		LastModified:  format.LastModified,
+		path:          path,
+	}
+}
+
+// loadDownloadJournal returns the journal at path if it belongs to the same
+// stream as want and its file is intact, or nil.
+func loadDownloadJournal(path string, want *downloadJournal, file os.FileInfo) *downloadJournal {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var j downloadJournal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil
+	}
+
+	if j.VideoID != want.VideoID || j.Itag != want.Itag ||
+		j.ContentLength != want.ContentLength || j.LastModified != want.LastModified ||
+		file == nil || file.Size() != want.ContentLength {
+		return nil
+	}
+
+	for _, r := range j.Completed {
+		if r[0] < 0 || r[1] < r[0] || r[1] >= j.ContentLength {
+			return nil
+		}
+	}
+
+	j.path = path
+	j.normalize()
+
+	return &j
+}
+
+// complete marks the inclusive range start-end as downloaded.
+func (j *downloadJournal) complete(start, end int64) {
+	j.Completed = append(j.Completed, [2]int64{start, end})
+	j.normalize()
+}
+
+// normalize sorts and merges the completed ranges.
+func (j *downloadJournal) normalize() {
+	sort.Slice(j.Completed, func(a, b int) bool {
+		return j.Completed[a][0] < j.Completed[b][0]
+	})
+
+	merged := j.Completed[:0]
+	for _, r := range j.Completed {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1]+1 {
+			merged[n-1][1] = max(merged[n-1][1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	j.Completed = merged
+}
+
+// completedBytes returns the number of bytes already downloaded.
+func (j *downloadJournal) completedBytes() (n int64) {
+	for _, r := range j.Completed {
+		n += r[1] - r[0] + 1
+	}
+	return n
+}
+
+// missing returns the ranges still to download, split in chunks.
+func (j *downloadJournal) missing(chunkSize int64) []chunk {
+	var chunks []chunk
+
+	add := func(start, end int64) {
+		chunks = append(chunks, getRangeChunks(start, end, chunkSize)...)
+	}
+
+	next := int64(0)
+	for _, r := range j.Completed {
+		if r[0] > next {
+			add(next, r[0]-1)
+		}
+		next = r[1] + 1
+	}
+
+	if next < j.ContentLength {
+		add(next, j.ContentLength-1)
+	}
+
+	return chunks
+}
+
+// save writes the journal atomically.
+func (j *downloadJournal) save() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, j.path)
+}
+
+// DownloadToFile downloads format to path. Chunks are written in place and
+// recorded in a journal next to the file, so calling it again after a failure
+// only downloads the missing ranges. The journal is removed on success, and
+// when the stream changed during the download, with ErrStreamChanged.
+func (c *Client) DownloadToFile(ctx context.Context, video *Video, format *Format, path string, opts ...DownloadOpts) error {
+	if format == nil {
+		return ErrNoFormat
+	}
+
+	optsMap := downloadoptions{}
+	for _, opt := range opts {
+		opt(&optsMap)
+	}
+
+	if optsMap.journalPath == "" {
+		optsMap.journalPath = path + JournalSuffix
+	}
+
+	if format.ContentLength == 0 {
+		// without length information, there is nothing to resume
+		return c.downloadToFileOnce(ctx, video, format, path, opts)
+	}
+
+	journal := newDownloadJournal(optsMap.journalPath, video, format)
+	info, _ := os.Stat(path)
+	if j := loadDownloadJournal(optsMap.journalPath, journal, info); j != nil {
+		journal = j
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if len(journal.Completed) == 0 {
+		if err := f.Truncate(format.ContentLength); err != nil {
+			return err
+		}
+	}
+
+	if err := journal.save(); err != nil {
+		return err
+	}
+
+	cinfo := contextInfo{
+		Self:   c,
+		Client: video.formatClient(format),
+	}
+	ctx = context.WithValue(ctx, contextKey("info"), cinfo)
+
+	tracker := newProgressTracker(optsMap.observers, format.ContentLength)
+	tracker.setResumed(journal.completedBytes())
+
+	if err := c.downloadChunksTo(ctx, c.newStreamSource(video, format), f, journal, tracker); err != nil {
+		if errors.Is(err, ErrStreamChanged) {
+			// the completed ranges belong to the previous stream
+			os.Remove(journal.path) //nolint:errcheck
+		}
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	return os.Remove(journal.path)
+}
+
+// downloadChunksTo downloads the missing chunks of journal in parallel,
+// writing each one to f at its offset and recording it in the journal once
+// it reached the disk.
+func (c *Client) downloadChunksTo(ctx context.Context, src *streamSource, f *os.File, journal *downloadJournal, tracker *progressTracker) error {
+	chunkSize := c.getChunkSize()
+	chunks := journal.missing(chunkSize)
+	maxRoutines := c.getMaxRoutines(len(chunks))
+	maxRoutines = min(maxRoutines, c.getMaxBufferedChunks(maxRoutines))
+	buffers := newChunkBuffers(chunkSize)
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	currentChunk := atomic.Uint32{}
+	for i := 0; i < maxRoutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				chunkIndex := int(currentChunk.Add(1)) - 1
+				if chunkIndex >= len(chunks) || cancelCtx.Err() != nil {
+					return
+				}
+
+				chunk := &chunks[chunkIndex]
+				buf := buffers.get(chunk.end - chunk.start + 1)
+				err := c.downloadChunk(cancelCtx, src, chunk, buf, tracker)
+				if err == nil {
+					_, err = f.WriteAt(<-chunk.data, chunk.start)
+				}
+				buffers.put(buf)
+				if err == nil {
+					// a range is only recorded once it is on disk
+					err = f.Sync()
+				}
+
+				if err != nil {
+					fail(err)
+					return
+				}
+
+				mu.Lock()
+				journal.complete(chunk.start, chunk.end)
+				err = journal.save()
+				mu.Unlock()
+
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return firstErr
+}
+
+func (c *Client) downloadToFileOnce(ctx context.Context, video *Video, format *Format, path string, opts []DownloadOpts) error {
+	stream, _, err := c.GetStreamContext(ctx, video, format, opts...)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, stream); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to download itag %d: %w", format.ItagNo, err)
+	}
+
+	return errors.Join(f.Sync(), f.Close())
+}
@@ -0,0 +1,145 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadToFileResume(t *testing.T) {
+	failing := true
+	client, transport := newFlakyClient(t, func(req *http.Request, n int) (*http.Response, error) {
+		// the connection drops for good in the middle of the download
+		if failing && req.URL.Path == "/videoplayback" && req.URL.Query().Get("range") == "61440-71679" {
+			return statusResponse(req, http.StatusNotFound, nil), nil
+		}
+		return nil, nil
+	})
+	client.ChunkSize = 10 * Size1Kb
+	client.MaxRoutines = 1
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+	format := &video.Formats.Itag(137)[0]
+
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	err = client.DownloadToFile(context.Background(), video, format, path)
+	if !errors.Is(err, ErrUnexpectedStatusCode(http.StatusNotFound)) {
+		t.Fatalf("DownloadToFile error = %v", err)
+	}
+
+	if _, err := os.Stat(path + JournalSuffix); err != nil {
+		t.Fatalf("journal missing after failure: %v", err)
+	}
+
+	failing = false
+	if err := client.DownloadToFile(context.Background(), video, format, path); err != nil {
+		t.Fatalf("DownloadToFile failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	want, _ := os.ReadFile("testdata/videoplayback/o-fixture.137")
+	if !bytes.Equal(data, want) {
+		t.Errorf("file content differs from fixture")
+	}
+
+	if _, err := os.Stat(path + JournalSuffix); !os.IsNotExist(err) {
+		t.Errorf("journal not removed: %v", err)
+	}
+
+	// the chunks before the failure were only downloaded once
+	if n := transport.count(func(u *url.URL) bool { return u.Query().Get("range") == "0-10239" }); n != 1 {
+		t.Errorf("first chunk requested %d times, want 1", n)
+	}
+	if n := transport.count(func(u *url.URL) bool { return u.Query().Get("range") == "71680-81919" }); n != 1 {
+		t.Errorf("chunk after the failure requested %d times, want 1", n)
+	}
+}
+
+func TestDownloadToFileStreamChanged(t *testing.T) {
+	client, _ := newFlakyClient(t, func(req *http.Request, n int) (*http.Response, error) {
+		// the URL expires in the middle of the download
+		if req.URL.Query().Get("range") == "20480-30719" {
+			return statusResponse(req, http.StatusForbidden, nil), nil
+		}
+		return nil, nil
+	})
+	client.ChunkSize = 10 * Size1Kb
+	client.MaxRoutines = 1
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+	// the stream was modified since the format was fetched
+	format := &video.Formats.Itag(137)[0]
+	format.LastModified = "1"
+
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	err = client.DownloadToFile(context.Background(), video, format, path)
+	if !errors.Is(err, ErrStreamChanged) {
+		t.Fatalf("DownloadToFile error = %v, want ErrStreamChanged", err)
+	}
+
+	if _, err := os.Stat(path + JournalSuffix); !os.IsNotExist(err) {
+		t.Errorf("journal of the previous stream kept: %v", err)
+	}
+}
+
+func TestDownloadJournal(t *testing.T) {
+	dir := t.TempDir()
+	video := &Video{ID: fixtureVideoID}
+	format := &Format{ItagNo: 140, ContentLength: 100, LastModified: "1"}
+
+	path := filepath.Join(dir, "journal")
+	j := newDownloadJournal(path, video, format)
+	j.complete(50, 59)
+	j.complete(0, 9)
+	j.complete(10, 19)
+	if err := j.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, make([]byte, 100), 0o644) //nolint:errcheck
+	info, _ := os.Stat(file)
+
+	loaded := loadDownloadJournal(path, newDownloadJournal(path, video, format), info)
+	if loaded == nil {
+		t.Fatal("journal not loaded")
+	}
+
+	var got [][2]int64
+	for _, c := range loaded.missing(20) {
+		got = append(got, [2]int64{c.start, c.end})
+	}
+
+	want := [][2]int64{{20, 39}, {40, 49}, {60, 79}, {80, 99}}
+	if len(got) != len(want) {
+		t.Fatalf("missing = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("missing = %v, want %v", got, want)
+		}
+	}
+
+	changed := *format
+	changed.LastModified = "2"
+	if loadDownloadJournal(path, newDownloadJournal(path, video, &changed), info) != nil {
+		t.Errorf("journal of a modified stream was loaded")
+	}
+
+	os.Truncate(file, 50) //nolint:errcheck
+	info, _ = os.Stat(file)
+	if loadDownloadJournal(path, newDownloadJournal(path, video, format), info) != nil {
+		t.Errorf("journal of a truncated file was loaded")
+	}
+}
@@ -0,0 +1,328 @@
+package youtubedl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// EBML element IDs used by the WebM parser and muxer.
+const (
+	ebmlIDHeader        = 0x1A45DFA3
+	ebmlIDSegment       = 0x18538067
+	ebmlIDSeekHead      = 0x114D9B74
+	ebmlIDInfo          = 0x1549A966
+	ebmlIDTimecodeScale = 0x2AD7B1
+	ebmlIDDuration      = 0x4489
+	ebmlIDTracks        = 0x1654AE6B
+	ebmlIDCues          = 0x1C53BB6B
+	ebmlIDCuePoint      = 0xBB
+	ebmlIDCueTime       = 0xB3
+	ebmlIDCueTrackPos   = 0xB7
+	ebmlIDCueTrack      = 0xF7
+	ebmlIDCueClusterPos = 0xF1
+	ebmlIDCluster       = 0x1F43B675
+	ebmlIDVoid          = 0xEC
+
+	ebmlIDSeek          = 0x4DBB
+	ebmlIDSeekID        = 0x53AB
+	ebmlIDSeekPosition  = 0x53AC
+	ebmlIDMuxingApp     = 0x4D80
+	ebmlIDWritingApp    = 0x5741
+	ebmlIDTrackEntry    = 0xAE
+	ebmlIDTrackNumber   = 0xD7
+	ebmlIDTrackUID      = 0x73C5
+	ebmlIDTrackType     = 0x83
+	ebmlIDCodecID       = 0x86
+	ebmlIDDefaultDur    = 0x23E383
+	ebmlIDCodecPrivate  = 0x63A2
+	ebmlIDTimecode      = 0xE7
+	ebmlIDSimpleBlock   = 0xA3
+	ebmlIDBlockGroup    = 0xA0
+	ebmlIDBlock         = 0xA1
+	ebmlIDBlockDuration = 0x9B
+	ebmlIDReferenceBlk  = 0xFB
+	ebmlIDDiscardPad    = 0x75A2
+)
+
+// ebmlUnknownSize is the size of elements that extend to the end of their parent.
+const ebmlUnknownSize = -1
+
+// ebmlElement is an EBML element read from a byte slice.
+type ebmlElement struct {
+	id     uint32
+	offset int64  // of the element header, relative to the parsed slice
+	header int    // length of the ID and size
+	size   int64  // of the data, or ebmlUnknownSize
+	data   []byte // the data, possibly truncated to the parsed slice
+}
+
+// readEBMLVint reads a variable length integer. With keepMarker, the
+// length marker is kept, as in element IDs.
+func readEBMLVint(b []byte, keepMarker bool) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, fmt.Errorf("ebml: truncated vint")
+	}
+
+	width := 1
+	for mask := byte(0x80); width <= 8 && b[0]&mask == 0; mask >>= 1 {
+		width++
+	}
+	if width > 8 {
+		return 0, 0, fmt.Errorf("ebml: invalid vint")
+	}
+	if len(b) < width {
+		return 0, 0, fmt.Errorf("ebml: truncated vint")
+	}
+
+	v := uint64(b[0])
+	if !keepMarker {
+		v &= 0xff >> width
+	}
+	for _, c := range b[1:width] {
+		v = v<<8 | uint64(c)
+	}
+
+	return v, width, nil
+}
+
+// readEBMLElements returns the elements stored one after the other in
+// data. The last element may be truncated: its data then holds what is
+// available, which allows parsing a Segment from its first bytes.
+func readEBMLElements(data []byte) ([]ebmlElement, error) {
+	var elements []ebmlElement
+
+	for offset := 0; offset < len(data); {
+		id, idWidth, err := readEBMLVint(data[offset:], true)
+		if err != nil {
+			return nil, fmt.Errorf("%w at offset %d", err, offset)
+		}
+
+		size, sizeWidth, err := readEBMLVint(data[offset+idWidth:], false)
+		if err != nil {
+			return nil, fmt.Errorf("%w at offset %d", err, offset)
+		}
+
+		e := ebmlElement{
+			id:     uint32(id),
+			offset: int64(offset),
+			header: idWidth + sizeWidth,
+			size:   int64(size),
+		}
+		if size == 1<<(7*sizeWidth)-1 {
+			e.size = ebmlUnknownSize
+		}
+
+		start := offset + e.header
+		end := len(data)
+		if e.size != ebmlUnknownSize && int64(end-start) > e.size {
+			end = start + int(e.size)
+		}
+		e.data = data[start:end]
+
+		elements = append(elements, e)
+		offset = end
+	}
+
+	return elements, nil
+}
+
+// findEBMLElement returns the first element with the given ID.
+func findEBMLElement(elements []ebmlElement, id uint32) *ebmlElement {
+	for i := range elements {
+		if elements[i].id == id {
+			return &elements[i]
+		}
+	}
+
+	return nil
+}
+
+func ebmlUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+
+	return v
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+
+	return 0
+}
+
+// ebmlIDBytes returns the encoded element ID.
+func ebmlIDBytes(id uint32) []byte {
+	switch {
+	case id > 0xFFFFFF:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFFFF:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFF:
+		return []byte{byte(id >> 8), byte(id)}
+	}
+
+	return []byte{byte(id)}
+}
+
+// ebmlSizeBytes returns size encoded as a vint of width bytes, or of the
+// smallest width holding it if width is zero.
+func ebmlSizeBytes(size uint64, width int) []byte {
+	if width == 0 {
+		width = 1
+		// all ones is reserved for unknown sizes
+		for width < 8 && size >= 1<<(7*width)-1 {
+			width++
+		}
+	}
+
+	b := make([]byte, width)
+	v := size | 1<<(7*width)
+	for i := width - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+
+	return b
+}
+
+// ebmlElementBytes returns an element holding payload.
+func ebmlElementBytes(id uint32, payload ...[]byte) []byte {
+	size := 0
+	for _, p := range payload {
+		size += len(p)
+	}
+
+	out := append(ebmlIDBytes(id), ebmlSizeBytes(uint64(size), 0)...)
+	for _, p := range payload {
+		out = append(out, p...)
+	}
+
+	return out
+}
+
+// ebmlUintBytes returns an unsigned integer element. A non-zero width
+// fixes the length of the value, so that it can be computed later.
+func ebmlUintBytes(id uint32, v uint64, width int) []byte {
+	if width == 0 {
+		width = 1
+		for width < 8 && v >= 1<<(8*width) {
+			width++
+		}
+	}
+
+	b := make([]byte, width)
+	for i := width - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+
+	return ebmlElementBytes(id, b)
+}
+
+func ebmlFloatBytes(id uint32, v float64) []byte {
+	return ebmlElementBytes(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func ebmlStringBytes(id uint32, s string) []byte {
+	return ebmlElementBytes(id, []byte(s))
+}
+
+// bytes returns the element with its header.
+func (e *ebmlElement) bytes() []byte {
+	return ebmlElementBytes(e.id, e.data)
+}
+
+// ebmlMaxElementSize bounds the elements read by ebmlStream.readData, whose
+// sizes come from the stream.
+const ebmlMaxElementSize = 1 << 30
+
+// ebmlStream reads the elements of a stream one at a time.
+type ebmlStream struct {
+	r      io.Reader
+	offset int64
+}
+
+// next reads the header of the next element, or returns io.EOF at the end
+// of the stream. Its data is left to read with readData or skip.
+func (s *ebmlStream) next() (ebmlElement, error) {
+	var buf [16]byte
+
+	if _, err := io.ReadFull(s.r, buf[:1]); err != nil {
+		return ebmlElement{}, err
+	}
+	idWidth := bits.LeadingZeros8(buf[0]) + 1
+	if idWidth > 4 {
+		return ebmlElement{}, fmt.Errorf("ebml: invalid element ID at offset %d", s.offset)
+	}
+	if _, err := io.ReadFull(s.r, buf[1:idWidth+1]); err != nil {
+		return ebmlElement{}, fmt.Errorf("ebml: truncated element header at offset %d", s.offset)
+	}
+	sizeWidth := bits.LeadingZeros8(buf[idWidth]) + 1
+	if sizeWidth > 8 {
+		return ebmlElement{}, fmt.Errorf("ebml: invalid element size at offset %d", s.offset)
+	}
+	if _, err := io.ReadFull(s.r, buf[idWidth+1:idWidth+sizeWidth]); err != nil {
+		return ebmlElement{}, fmt.Errorf("ebml: truncated element header at offset %d", s.offset)
+	}
+
+	id, _, _ := readEBMLVint(buf[:idWidth], true)
+	size, _, _ := readEBMLVint(buf[idWidth:idWidth+sizeWidth], false)
+
+	e := ebmlElement{
+		id:     uint32(id),
+		offset: s.offset,
+		header: idWidth + sizeWidth,
+		size:   int64(size),
+	}
+	if size == 1<<(7*sizeWidth)-1 {
+		e.size = ebmlUnknownSize
+	}
+	s.offset += int64(e.header)
+
+	return e, nil
+}
+
+// readData reads the data of e.
+func (s *ebmlStream) readData(e *ebmlElement) error {
+	if e.size == ebmlUnknownSize {
+		return fmt.Errorf("ebml: element %x at offset %d has an unknown size", e.id, e.offset)
+	}
+
+	if e.size > ebmlMaxElementSize {
+		return fmt.Errorf("ebml: element %x at offset %d is too large: %d bytes", e.id, e.offset, e.size)
+	}
+
+	data, err := readSized(s.r, e.size)
+	e.data = data
+	s.offset += int64(len(data))
+	if err != nil {
+		return fmt.Errorf("ebml: truncated element %x at offset %d: %w", e.id, e.offset, err)
+	}
+
+	return nil
+}
+
+// skip discards the data of e.
+func (s *ebmlStream) skip(e *ebmlElement) error {
+	if e.size == ebmlUnknownSize {
+		return fmt.Errorf("ebml: element %x at offset %d has an unknown size", e.id, e.offset)
+	}
+
+	n, err := io.CopyN(io.Discard, s.r, e.size)
+	s.offset += n
+	if err != nil {
+		return fmt.Errorf("ebml: truncated element %x at offset %d: %w", e.id, e.offset, err)
+	}
+
+	return nil
+}
@@ -14,6 +14,19 @@ const (
 	ErrLoginRequired              = constError("login required to confirm your age")
 	ErrVideoPrivate               = constError("user restricted access to this video")
 	ErrInvalidPlaylist            = constError("no playlist detected or invalid playlist ID")
+	ErrPlayerIDNotFound           = constError("player id not found")
+	ErrStreamClosed               = constError("stream closed")
+	ErrContentLengthUnknown       = constError("content length unknown")
+	ErrInvalidRange               = constError("invalid byte range")
+	ErrNoInitRange                = constError("format has no init range")
+	ErrNoIndexRange               = constError("format has no index range")
+	ErrNoSegmentsInRange          = constError("no segments in time range")
+	ErrUnsupportedContainer       = constError("unsupported container")
+	ErrNoAudioFormat              = constError("no suitable audio format")
+	ErrInvalidSelector            = constError("invalid format selector")
+	ErrNoMatchingFormat           = constError("no format matches the selector")
+	ErrNoFormats                  = constError("no formats found in the server's answer")
+	ErrStreamChanged              = constError("stream changed since the format was fetched")
 )
 
 type constError string
@@ -38,6 +51,19 @@ func (err ErrUnexpectedStatusCode) Error() string {
 	return fmt.Sprintf("unexpected status code: %d", err)
 }
 
+// ErrPlayerLoad is returned by calls that need the player when it could not be loaded
+type ErrPlayerLoad struct {
+	Err error
+}
+
+func (err *ErrPlayerLoad) Error() string {
+	return fmt.Sprintf("could not load player: %v", err.Err)
+}
+
+func (err *ErrPlayerLoad) Unwrap() error {
+	return err.Err
+}
+
 type ErrPlaylistStatus struct {
 	Reason string
 }
@@ -0,0 +1,164 @@
+package youtubedl
+
+import (
+	"cmp"
+	"strconv"
+)
+
+// FormatRanker orders formats for FormatList.Sort.
+type FormatRanker interface {
+	// Compare returns a negative number when a ranks before b, a positive
+	// number when b ranks before a and zero without preference, leaving the
+	// decision to the next ranker.
+	Compare(a, b *Format) int
+}
+
+// FormatRankerFunc adapts a function to a FormatRanker.
+type FormatRankerFunc func(a, b *Format) int
+
+func (f FormatRankerFunc) Compare(a, b *Format) int {
+	return f(a, b)
+}
+
+// DefaultRanker sorts video by resolution, FPS, codec (av01, vp9, avc1) and
+// bitrate, and audio by default track, codec (mp4a, opus), channels, bitrate
+// and sample rate. It breaks the ties left by the rankers given to Sort.
+var DefaultRanker FormatRanker = FormatRankerFunc(func(a, b *Format) int {
+	switch {
+	case sortFormat(a, b):
+		return -1
+	case sortFormat(b, a):
+		return 1
+	}
+	return 0
+})
+
+// QualityFirst ranks formats by resolution, frame rate, audio channels,
+// bitrate and sample rate, regardless of their codec.
+var QualityFirst FormatRanker = FormatRankerFunc(func(a, b *Format) int {
+	return cmp.Or(
+		cmp.Compare(b.Width*b.Height, a.Width*a.Height),
+		cmp.Compare(b.FPS, a.FPS),
+		cmp.Compare(b.AudioChannels, a.AudioChannels),
+		cmp.Compare(b.Bitrate, a.Bitrate),
+		cmp.Compare(sampleRate(b), sampleRate(a)),
+	)
+})
+
+// SmallestSize ranks the smallest formats first. The size of a format without
+// a content length is estimated from its bitrate and duration, and formats of
+// unknown size come last.
+var SmallestSize FormatRanker = FormatRankerFunc(func(a, b *Format) int {
+	sizeA, sizeB := formatSize(a), formatSize(b)
+	if sizeA == 0 || sizeB == 0 {
+		// unknown sizes last
+		return cmp.Compare(sizeB, sizeA)
+	}
+	return cmp.Compare(sizeA, sizeB)
+})
+
+// CompatibilityFirst ranks first the formats whose codecs all belong to the
+// given families, such as avc1 and mp4a for players that only decode H.264
+// and AAC, which are the default.
+func CompatibilityFirst(families ...string) FormatRanker {
+	if len(families) == 0 {
+		families = []string{"avc1", "mp4a"}
+	}
+
+	supported := make(map[string]bool, len(families))
+	for _, family := range families {
+		supported[family] = true
+	}
+
+	compatible := func(f *Format) bool {
+		codecs := f.Codecs()
+		for _, c := range codecs {
+			if !supported[c.Family] {
+				return false
+			}
+		}
+		return len(codecs) > 0
+	}
+
+	return FormatRankerFunc(func(a, b *Format) int {
+		return compareBool(compatible(a), compatible(b))
+	})
+}
+
+// BandwidthCapped ranks first the formats whose bitrate fits in maxBitrate bits
+// per second, leaving their order to the next rankers, and then the others
+// from the lowest bitrate. Formats of unknown bitrate come last.
+func BandwidthCapped(maxBitrate int) FormatRanker {
+	return FormatRankerFunc(func(a, b *Format) int {
+		bitrateA, bitrateB := formatBitrate(a), formatBitrate(b)
+		fitsA := bitrateA > 0 && bitrateA <= maxBitrate
+		fitsB := bitrateB > 0 && bitrateB <= maxBitrate
+
+		switch {
+		case fitsA && fitsB:
+			return 0
+		case fitsA || fitsB:
+			return compareBool(fitsA, fitsB)
+		case bitrateA == 0 || bitrateB == 0:
+			return cmp.Compare(bitrateB, bitrateA)
+		}
+		return cmp.Compare(bitrateA, bitrateB)
+	})
+}
+
+// chainRankers returns a ranker asking each ranker in turn, and then
+// DefaultRanker.
+func chainRankers(rankers []FormatRanker) FormatRanker {
+	if len(rankers) == 0 {
+		return DefaultRanker
+	}
+
+	return FormatRankerFunc(func(a, b *Format) int {
+		for _, r := range rankers {
+			if c := r.Compare(a, b); c != 0 {
+				return c
+			}
+		}
+		return DefaultRanker.Compare(a, b)
+	})
+}
+
+// compareBool ranks true before false.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	}
+	return 1
+}
+
+// formatBitrate returns the peak bitrate of f, or its average bitrate.
+func formatBitrate(f *Format) int {
+	if f.Bitrate > 0 {
+		return f.Bitrate
+	}
+	return f.AverageBitrate
+}
+
+// formatSize returns the content length of f or an estimate of it, zero when
+// unknown.
+func formatSize(f *Format) int64 {
+	if f.ContentLength > 0 {
+		return f.ContentLength
+	}
+
+	bitrate := f.AverageBitrate
+	if bitrate == 0 {
+		bitrate = f.Bitrate
+	}
+	ms, _ := strconv.ParseInt(f.ApproxDurationMs, 10, 64)
+
+	return int64(bitrate) * ms / 8000
+}
+
+func sampleRate(f *Format) int {
+	rate, _ := strconv.Atoi(f.AudioSampleRate)
+	return rate
+}
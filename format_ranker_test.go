@@ -0,0 +1,142 @@
+package youtubedl
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// rankerFormats is selectorFormats with bitrates for the adaptive video
+// formats and a duration for all but the progressive one.
+func rankerFormats() FormatList {
+	bitrates := map[int]int{401: 12000000, 137: 4000000, 248: 2500000, 136: 1500000}
+
+	formats := selectorFormats()
+	for i := range formats {
+		if bitrate, ok := bitrates[formats[i].ItagNo]; ok {
+			formats[i].Bitrate = bitrate
+		}
+		if formats[i].ItagNo != 18 {
+			formats[i].ApproxDurationMs = "600000"
+		}
+	}
+
+	return formats
+}
+
+// rankedItags lists the itags of formats, suffixing the language of
+// non-default audio tracks.
+func rankedItags(formats FormatList) string {
+	var out []string
+	for _, f := range formats {
+		label := strconv.Itoa(f.ItagNo)
+		if f.AudioTrack != nil && !f.AudioTrack.AudioIsDefault {
+			lang, _, _ := strings.Cut(f.AudioTrack.ID, ".")
+			label += lang
+		}
+		out = append(out, label)
+	}
+
+	return strings.Join(out, " ")
+}
+
+func TestFormatRankers(t *testing.T) {
+	tests := []struct {
+		name    string
+		rankers []FormatRanker
+		want    string
+	}{
+		{"default", nil, "401 248 137 136 18 140 139 251 140es-419"},
+		{"default ranker", []FormatRanker{DefaultRanker}, "401 248 137 136 18 140 139 251 140es-419"},
+		{"compatibility", []FormatRanker{CompatibilityFirst()}, "137 136 18 140 139 140es-419 401 248 251"},
+		{"compatibility webm", []FormatRanker{CompatibilityFirst("vp9", "opus")}, "248 251 401 137 136 18 140 139 140es-419"},
+		{"compatibility none", []FormatRanker{CompatibilityFirst("hev1")}, "401 248 137 136 18 140 139 251 140es-419"},
+		{"quality", []FormatRanker{QualityFirst}, "401 137 248 136 18 251 140 140es-419 139"},
+		{"smallest", []FormatRanker{SmallestSize}, "139 140 140es-419 251 136 248 137 401 18"},
+		{"bandwidth", []FormatRanker{BandwidthCapped(3000000)}, "248 136 18 140 139 251 140es-419 137 401"},
+		{"bandwidth low", []FormatRanker{BandwidthCapped(100000)}, "139 140 140es-419 251 18 136 248 137 401"},
+		{"compatibility then quality", []FormatRanker{CompatibilityFirst(), QualityFirst}, "137 136 18 140 140es-419 139 401 248 251"},
+		{"bandwidth then quality", []FormatRanker{BandwidthCapped(3000000), QualityFirst}, "248 136 18 251 140 140es-419 139 137 401"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formats := rankerFormats()
+			formats.Sort(tt.rankers...)
+
+			if got := rankedItags(formats); got != tt.want {
+				t.Errorf("order = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSmallestSizeUnknown(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Format
+		want int
+	}{
+		{"content length", Format{ContentLength: 100}, Format{ContentLength: 200}, -1},
+		{"estimated", Format{AverageBitrate: 8000, ApproxDurationMs: "1000"}, Format{ContentLength: 500}, 1},
+		{"average bitrate first", Format{Bitrate: 16000, AverageBitrate: 800, ApproxDurationMs: "1000"}, Format{ContentLength: 500}, -1},
+		{"unknown", Format{Bitrate: 8000}, Format{ContentLength: 1 << 40}, 1},
+		{"both unknown", Format{}, Format{Bitrate: 8000}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := SmallestSize.Compare(&tt.a, &tt.b); got != tt.want {
+			t.Errorf("%s: Compare = %d, want %d", tt.name, got, tt.want)
+		}
+		if got := SmallestSize.Compare(&tt.b, &tt.a); got != -tt.want {
+			t.Errorf("%s: reversed Compare = %d, want %d", tt.name, got, -tt.want)
+		}
+	}
+}
+
+func TestBandwidthCappedUnknown(t *testing.T) {
+	ranker := BandwidthCapped(1000)
+
+	tests := []struct {
+		name string
+		a, b Format
+		want int
+	}{
+		{"both fit", Format{Bitrate: 500}, Format{Bitrate: 900}, 0},
+		{"at the cap", Format{Bitrate: 1000}, Format{Bitrate: 1001}, -1},
+		{"both over", Format{Bitrate: 3000}, Format{Bitrate: 2000}, 1},
+		{"average bitrate", Format{AverageBitrate: 800}, Format{Bitrate: 2000}, -1},
+		{"unknown", Format{}, Format{Bitrate: 5000}, 1},
+		{"both unknown", Format{}, Format{}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := ranker.Compare(&tt.a, &tt.b); got != tt.want {
+			t.Errorf("%s: Compare = %d, want %d", tt.name, got, tt.want)
+		}
+		if got := ranker.Compare(&tt.b, &tt.a); got != -tt.want {
+			t.Errorf("%s: reversed Compare = %d, want %d", tt.name, got, -tt.want)
+		}
+	}
+}
+
+func TestFilterQualityRankers(t *testing.T) {
+	video := &Video{Formats: rankerFormats()}
+	video.FilterQuality("1080p", QualityFirst)
+
+	if got := rankedItags(video.Formats); got != "137 248" {
+		t.Errorf("formats = %s, want 137 248", got)
+	}
+}
+
+func TestGetVideoFormatRanker(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureMediaID, WithFormatRanker(CompatibilityFirst("vp9", "opus")))
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+	if got := rankedItags(video.Formats); got != "247 251 136 140" {
+		t.Errorf("formats = %s, want 247 251 136 140", got)
+	}
+}
@@ -0,0 +1,505 @@
+package youtubedl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FormatSelector chooses a format, or a video and an audio format to merge,
+// from a FormatList. It is parsed from expressions such as
+//
+//	bv[height<=1080][vcodec^=avc1]+ba[lang=en]/b
+//
+// Alternatives separated by / are tried in order, and the first matching
+// one is used. Each alternative is a single format, or a video format and
+// an audio format joined by +. A format is chosen by a base followed by
+// filters in brackets:
+//
+//	b, best         the best format with video and audio
+//	w, worst        the worst format with video and audio
+//	bv, bestvideo   the best video only format
+//	wv, worstvideo  the worst video only format
+//	ba, bestaudio   the best audio only format
+//	wa, worstaudio  the worst audio only format
+//	bv*, ba*, ...   the same, also matching formats with video and audio
+//	<itag>          the format with this itag
+//
+// Formats are ranked as by FormatList.Sort. A filter compares a field of
+// the format to a value:
+//
+//	itag, width, height, fps, bitrate, asr, channels, filesize
+//	                with =, !=, <, <=, > and >=; sizes accept a K, M or G suffix
+//	ext, vcodec, acodec, lang, quality
+//	                with =, != and the prefix, suffix and substring
+//	                operators ^=, $= and *=
+//
+// ext is mp4, m4a or webm, vcodec and acodec are none for a missing
+// stream, lang is the language code of the audio track and quality the
+// quality label, such as 1080p60. Formats missing a field do not match
+// unless the operator is followed by ?, as in [height<=?1080].
+//
+// FormatSelector implements encoding.TextUnmarshaler, so that it can be
+// read from configuration files.
+type FormatSelector struct {
+	text         string
+	alternatives []selectorAlternative
+}
+
+// Selection is the result of a FormatSelector.
+type Selection struct {
+	// Format is the chosen format, or the video format when Audio is set.
+	Format *Format
+
+	// Audio is the audio format to merge with Format, for alternatives
+	// joining two formats with +.
+	Audio *Format
+}
+
+// Merged reports whether the selection is a pair of formats to merge, with
+// Client.DownloadMerged for example.
+func (s Selection) Merged() bool {
+	return s.Audio != nil
+}
+
+type selectorAlternative struct {
+	text  string
+	parts []selectorPart // one format, or two to merge
+}
+
+type selectorPart struct {
+	text    string
+	base    string // best, worst or an itag
+	kind    string // a key of selectorKinds, empty for an itag
+	filters []selectorFilter
+}
+
+// selectorKinds describes the formats matched by each kind of base.
+var selectorKinds = map[string]string{
+	"both":       "format with video and audio",
+	"video":      "video only format",
+	"audio":      "audio only format",
+	"with video": "format with video",
+	"with audio": "format with audio",
+}
+
+type selectorFilter struct {
+	text     string
+	field    string
+	op       string
+	value    string
+	number   int64
+	optional bool
+}
+
+// selector fields by type
+var (
+	selectorNumberFields = map[string]bool{
+		"itag": true, "width": true, "height": true, "fps": true,
+		"bitrate": true, "asr": true, "channels": true, "filesize": true,
+	}
+	selectorStringFields = map[string]bool{
+		"ext": true, "vcodec": true, "acodec": true, "lang": true, "quality": true,
+	}
+)
+
+// selector operators, longest first
+var selectorOperators = []string{"<=", ">=", "!=", "^=", "$=", "*=", "=", "<", ">"}
+
+// ParseFormatSelector parses a format selector expression.
+func ParseFormatSelector(s string) (*FormatSelector, error) {
+	sel := &FormatSelector{text: s}
+
+	for _, alt := range splitSelector(s, '/') {
+		alternative := selectorAlternative{text: strings.TrimSpace(alt)}
+
+		parts := splitSelector(alt, '+')
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("%w: %q joins more than two formats", ErrInvalidSelector, alternative.text)
+		}
+
+		for _, p := range parts {
+			part, err := parseSelectorPart(strings.TrimSpace(p))
+			if err != nil {
+				return nil, err
+			}
+			alternative.parts = append(alternative.parts, part)
+		}
+
+		sel.alternatives = append(sel.alternatives, alternative)
+	}
+
+	return sel, nil
+}
+
+// splitSelector splits s at sep outside of brackets.
+func splitSelector(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+func parseSelectorPart(s string) (selectorPart, error) {
+	part := selectorPart{text: s}
+
+	base, rest := s, ""
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		base, rest = strings.TrimSpace(s[:i]), s[i:]
+	}
+
+	switch strings.TrimSuffix(base, "*") {
+	case "b", "best":
+		part.base, part.kind = "best", "both"
+	case "w", "worst":
+		part.base, part.kind = "worst", "both"
+	case "bv", "bestvideo":
+		part.base, part.kind = "best", "video"
+	case "wv", "worstvideo":
+		part.base, part.kind = "worst", "video"
+	case "ba", "bestaudio":
+		part.base, part.kind = "best", "audio"
+	case "wa", "worstaudio":
+		part.base, part.kind = "worst", "audio"
+	default:
+		if _, err := strconv.Atoi(base); err != nil || base == "" {
+			return part, fmt.Errorf("%w: unknown format %q", ErrInvalidSelector, base)
+		}
+		part.base = base
+	}
+
+	if strings.HasSuffix(base, "*") {
+		switch part.kind {
+		case "video":
+			part.kind = "with video"
+		case "audio":
+			part.kind = "with audio"
+		default:
+			return part, fmt.Errorf("%w: unknown format %q", ErrInvalidSelector, base)
+		}
+	}
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end < 0 {
+			return part, fmt.Errorf("%w: unterminated filter in %q", ErrInvalidSelector, s)
+		}
+
+		filter, err := parseSelectorFilter(rest[:end+1])
+		if err != nil {
+			return part, err
+		}
+		part.filters = append(part.filters, filter)
+		rest = rest[end+1:]
+	}
+
+	return part, nil
+}
+
+// parseSelectorFilter parses a filter in brackets.
+func parseSelectorFilter(s string) (selectorFilter, error) {
+	filter := selectorFilter{text: s}
+	expr := s[1 : len(s)-1]
+
+	i := strings.IndexAny(expr, "=!<>^$*")
+	if i < 0 {
+		return filter, fmt.Errorf("%w: no operator in %s", ErrInvalidSelector, s)
+	}
+	filter.field = strings.TrimSpace(expr[:i])
+
+	for _, op := range selectorOperators {
+		if strings.HasPrefix(expr[i:], op) {
+			filter.op = op
+			break
+		}
+	}
+	if filter.op == "" {
+		return filter, fmt.Errorf("%w: unknown operator in %s", ErrInvalidSelector, s)
+	}
+
+	value := expr[i+len(filter.op):]
+	if strings.HasPrefix(value, "?") {
+		filter.optional = true
+		value = value[1:]
+	}
+	filter.value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+	switch {
+	case selectorNumberFields[filter.field]:
+		switch filter.op {
+		case "^=", "$=", "*=":
+			return filter, fmt.Errorf("%w: %s cannot be used with %s", ErrInvalidSelector, filter.op, filter.field)
+		}
+
+		n, err := parseSelectorNumber(filter.value)
+		if err != nil {
+			return filter, fmt.Errorf("%w: invalid number in %s", ErrInvalidSelector, s)
+		}
+		filter.number = n
+
+	case selectorStringFields[filter.field]:
+		switch filter.op {
+		case "<", "<=", ">", ">=":
+			return filter, fmt.Errorf("%w: %s cannot be used with %s", ErrInvalidSelector, filter.op, filter.field)
+		}
+
+	default:
+		return filter, fmt.Errorf("%w: unknown field %q in %s", ErrInvalidSelector, filter.field, s)
+	}
+
+	return filter, nil
+}
+
+// parseSelectorNumber parses an integer with an optional K, M or G suffix,
+// possibly followed by B or iB.
+func parseSelectorNumber(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "i")
+
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"), strings.HasSuffix(s, "k"):
+		unit = Size1Kb
+	case strings.HasSuffix(s, "M"):
+		unit = Size1Mb
+	case strings.HasSuffix(s, "G"):
+		unit = Size1Mb * Size1Kb
+	}
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(n * float64(unit)), nil
+}
+
+// String returns the expression the selector was parsed from.
+func (sel *FormatSelector) String() string {
+	return sel.text
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (sel *FormatSelector) MarshalText() ([]byte, error) {
+	return []byte(sel.text), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (sel *FormatSelector) UnmarshalText(text []byte) error {
+	parsed, err := ParseFormatSelector(string(text))
+	if err != nil {
+		return err
+	}
+
+	*sel = *parsed
+
+	return nil
+}
+
+// Select returns the formats chosen by the first matching alternative. If
+// none matches, the error names the clause that failed in each of them.
+func (sel *FormatSelector) Select(list FormatList) (Selection, error) {
+	var reasons []string
+
+	for _, alt := range sel.alternatives {
+		var formats []*Format
+		for _, part := range alt.parts {
+			format, reason := part.match(list)
+			if format == nil {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", alt.text, reason))
+				break
+			}
+			formats = append(formats, format)
+		}
+
+		if len(formats) == len(alt.parts) {
+			selection := Selection{Format: formats[0]}
+			if len(formats) == 2 {
+				selection.Audio = formats[1]
+			}
+			return selection, nil
+		}
+	}
+
+	return Selection{}, fmt.Errorf("%w: %s", ErrNoMatchingFormat, strings.Join(reasons, "; "))
+}
+
+// SelectFormat parses selector and returns the formats it chooses.
+func (list FormatList) SelectFormat(selector string) (Selection, error) {
+	sel, err := ParseFormatSelector(selector)
+	if err != nil {
+		return Selection{}, err
+	}
+
+	return sel.Select(list)
+}
+
+// match returns the format chosen by part, or the reason nothing matched.
+func (part *selectorPart) match(list FormatList) (*Format, string) {
+	candidates := list.Select(func(f Format) bool {
+		kind := f.Kind()
+		hasVideo := kind == MediaVideo || kind == MediaMuxed
+		hasAudio := kind == MediaAudio || kind == MediaMuxed
+
+		switch part.kind {
+		case "both":
+			return hasVideo && hasAudio
+		case "video":
+			return hasVideo && !hasAudio
+		case "audio":
+			return hasAudio && !hasVideo
+		case "with video":
+			return hasVideo
+		case "with audio":
+			return hasAudio
+		}
+
+		return strconv.Itoa(f.ItagNo) == part.base
+	})
+	if len(candidates) == 0 {
+		if part.kind == "" {
+			return nil, "no format with itag " + part.base
+		}
+		return nil, "no " + selectorKinds[part.kind]
+	}
+
+	for _, filter := range part.filters {
+		candidates = candidates.Select(filter.match)
+		if len(candidates) == 0 {
+			return nil, "no format matches " + filter.text
+		}
+	}
+
+	candidates.Sort()
+	if part.base == "worst" {
+		return &candidates[len(candidates)-1], ""
+	}
+
+	return &candidates[0], ""
+}
+
+// match reports whether f passes the filter.
+func (filter *selectorFilter) match(f Format) bool {
+	if selectorNumberFields[filter.field] {
+		n, known := selectorNumber(&f, filter.field)
+		if !known {
+			return filter.optional
+		}
+
+		switch filter.op {
+		case "=":
+			return n == filter.number
+		case "!=":
+			return n != filter.number
+		case "<":
+			return n < filter.number
+		case "<=":
+			return n <= filter.number
+		case ">":
+			return n > filter.number
+		case ">=":
+			return n >= filter.number
+		}
+
+		return false
+	}
+
+	s, known := selectorString(&f, filter.field)
+	if !known {
+		return filter.optional
+	}
+
+	switch filter.op {
+	case "=":
+		return s == filter.value
+	case "!=":
+		return s != filter.value
+	case "^=":
+		return strings.HasPrefix(s, filter.value)
+	case "$=":
+		return strings.HasSuffix(s, filter.value)
+	case "*=":
+		return strings.Contains(s, filter.value)
+	}
+
+	return false
+}
+
+// selectorNumber returns a numeric field of f, unknown when zero.
+func selectorNumber(f *Format, field string) (int64, bool) {
+	var n int64
+	switch field {
+	case "itag":
+		return int64(f.ItagNo), true
+	case "width":
+		n = int64(f.Width)
+	case "height":
+		n = int64(f.Height)
+	case "fps":
+		n = int64(f.FPS)
+	case "bitrate":
+		n = int64(f.Bitrate)
+	case "asr":
+		n, _ = strconv.ParseInt(f.AudioSampleRate, 10, 64)
+	case "channels":
+		n = int64(f.AudioChannels)
+	case "filesize":
+		n = f.ContentLength
+	}
+
+	return n, n != 0
+}
+
+// selectorString returns a string field of f.
+func selectorString(f *Format, field string) (string, bool) {
+	switch field {
+	case "ext":
+		ext := f.Extension()
+		return ext, ext != ""
+	case "vcodec":
+		if f.IsAudioOnly() {
+			return "none", true
+		}
+		if c := f.VideoCodec(); c != nil {
+			return c.Name, true
+		}
+		return "", false
+	case "acodec":
+		if f.IsVideoOnly() {
+			return "none", true
+		}
+		if c := f.AudioCodec(); c != nil {
+			return c.Name, true
+		}
+		return "", false
+	case "lang":
+		if f.AudioTrack == nil {
+			return "", false
+		}
+		lang, _, _ := strings.Cut(f.AudioTrack.ID, ".")
+		return lang, true
+	case "quality":
+		if f.QualityLabel != "" {
+			return f.QualityLabel, true
+		}
+		return f.Quality, f.Quality != ""
+	}
+
+	return "", false
+}
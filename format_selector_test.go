@@ -0,0 +1,183 @@
+package youtubedl
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// selectorFormats is a typical format list, from best to worst video.
+func selectorFormats() FormatList {
+	track := func(id string, def bool) *struct {
+		DisplayName    string `json:"displayName"`
+		ID             string `json:"id"`
+		AudioIsDefault bool   `json:"audioIsDefault"`
+	} {
+		return &struct {
+			DisplayName    string `json:"displayName"`
+			ID             string `json:"id"`
+			AudioIsDefault bool   `json:"audioIsDefault"`
+		}{ID: id, AudioIsDefault: def}
+	}
+
+	return FormatList{
+		{ItagNo: 18, MimeType: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, Width: 640, Height: 360, FPS: 30, AudioChannels: 2, QualityLabel: "360p", Bitrate: 500000},
+		{ItagNo: 401, MimeType: `video/mp4; codecs="av01.0.12M.08"`, Width: 3840, Height: 2160, FPS: 30, QualityLabel: "2160p", ContentLength: 900 * Size1Mb},
+		{ItagNo: 137, MimeType: `video/mp4; codecs="avc1.640028"`, Width: 1920, Height: 1080, FPS: 30, QualityLabel: "1080p", ContentLength: 200 * Size1Mb},
+		{ItagNo: 248, MimeType: `video/webm; codecs="vp9"`, Width: 1920, Height: 1080, FPS: 30, QualityLabel: "1080p", ContentLength: 150 * Size1Mb},
+		{ItagNo: 136, MimeType: `video/mp4; codecs="avc1.4d401f"`, Width: 1280, Height: 720, FPS: 30, QualityLabel: "720p", ContentLength: 80 * Size1Mb},
+		{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AudioChannels: 2, Bitrate: 130000, AudioSampleRate: "44100", AudioTrack: track("en.4", true)},
+		{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AudioChannels: 2, Bitrate: 130000, AudioSampleRate: "44100", AudioTrack: track("es-419.3", false)},
+		{ItagNo: 251, MimeType: `audio/webm; codecs="opus"`, AudioChannels: 2, Bitrate: 140000, AudioSampleRate: "48000", AudioTrack: track("en.4", true)},
+		{ItagNo: 139, MimeType: `audio/mp4; codecs="mp4a.40.5"`, AudioChannels: 2, Bitrate: 50000, AudioSampleRate: "22050", AudioTrack: track("en.4", true)},
+	}
+}
+
+func TestFormatSelector(t *testing.T) {
+	formats := selectorFormats()
+
+	tests := []struct {
+		selector string
+		itag     int
+		audio    int // 0 for a single format
+		lang     string
+	}{
+		{"b", 18, 0, ""},
+		{"best", 18, 0, ""},
+		{"w", 18, 0, ""},
+		{"bv", 401, 0, ""},
+		{"wv", 136, 0, ""},
+		{"bv*", 401, 0, ""},
+		{"ba", 140, 0, "en.4"},
+		{"wa", 140, 0, "es-419.3"},
+		{"wa[lang=en][ext=m4a]", 139, 0, ""},
+		{"ba*[vcodec!=none]", 18, 0, ""},
+		{"137", 137, 0, ""},
+		{"bv[height<=1080]", 248, 0, ""},
+		{"bv[height<=1080][vcodec^=avc1]+ba[lang=en]/b", 137, 140, "en.4"},
+		{"bv[height<=720]+ba[lang=es-419]", 136, 140, "es-419.3"},
+		{"bv[ext=webm]+ba[ext=webm]", 248, 251, ""},
+		{"ba[ext=m4a][asr<44100]", 139, 0, ""},
+		{"ba[acodec=opus]", 251, 0, ""},
+		{"bv[filesize<100M]", 136, 0, ""},
+		{"bv[filesize<=?100M]", 136, 0, ""},
+		{"bv[quality=2160p]", 401, 0, ""},
+		{"bv[height>2160]/bv[vcodec*=vp9]", 248, 0, ""},
+		{"b[height>=720]/ba", 140, 0, "en.4"},
+		{"ba[lang=fr]/bv[lang=?fr]", 401, 0, ""},
+		{" bv [ fps = 30 ] + ba / b ", 401, 140, "en.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			selection, err := formats.SelectFormat(tt.selector)
+			if err != nil {
+				t.Fatalf("SelectFormat failed: %v", err)
+			}
+
+			if selection.Format.ItagNo != tt.itag {
+				t.Errorf("format = %d, want %d", selection.Format.ItagNo, tt.itag)
+			}
+
+			switch {
+			case tt.audio == 0 && selection.Merged():
+				t.Errorf("audio = %d, want none", selection.Audio.ItagNo)
+			case tt.audio != 0 && !selection.Merged():
+				t.Errorf("audio = none, want %d", tt.audio)
+			case tt.audio != 0 && selection.Audio.ItagNo != tt.audio:
+				t.Errorf("audio = %d, want %d", selection.Audio.ItagNo, tt.audio)
+			}
+
+			audio := selection.Format
+			if selection.Merged() {
+				audio = selection.Audio
+			}
+			if tt.lang != "" && audio.audioTrackID() != tt.lang {
+				t.Errorf("audio track = %q, want %q", audio.audioTrackID(), tt.lang)
+			}
+		})
+	}
+}
+
+func TestFormatSelectorNoMatch(t *testing.T) {
+	formats := selectorFormats()
+
+	tests := []struct {
+		selector string
+		clauses  []string
+	}{
+		{"bv[height>2160]", []string{"bv[height>2160]: no format matches [height>2160]"}},
+		{"bv[vcodec^=avc1][fps>30]+ba/ba[lang=de]", []string{
+			"bv[vcodec^=avc1][fps>30]+ba: no format matches [fps>30]",
+			"ba[lang=de]: no format matches [lang=de]",
+		}},
+		{"22", []string{"22: no format with itag 22"}},
+		{"bv+ba[channels=6]", []string{"bv+ba[channels=6]: no format matches [channels=6]"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			_, err := formats.SelectFormat(tt.selector)
+			if !errors.Is(err, ErrNoMatchingFormat) {
+				t.Fatalf("err = %v, want ErrNoMatchingFormat", err)
+			}
+			for _, clause := range tt.clauses {
+				if !strings.Contains(err.Error(), clause) {
+					t.Errorf("error %q does not mention %q", err, clause)
+				}
+			}
+		})
+	}
+
+	if _, err := formats[1:].SelectFormat("b"); err == nil || !strings.Contains(err.Error(), "b: no format with video and audio") {
+		t.Errorf("b without progressive formats: err = %v", err)
+	}
+}
+
+func TestParseFormatSelectorErrors(t *testing.T) {
+	for _, selector := range []string{
+		"",
+		"bestest",
+		"b*",
+		"bv+ba+ba",
+		"bv[height<=1080",
+		"bv[height]",
+		"bv[size<10]",
+		"bv[height^=10]",
+		"bv[vcodec>avc1]",
+		"bv[height<=tall]",
+		"bv/",
+	} {
+		if _, err := ParseFormatSelector(selector); !errors.Is(err, ErrInvalidSelector) {
+			t.Errorf("ParseFormatSelector(%q): err = %v, want ErrInvalidSelector", selector, err)
+		}
+	}
+}
+
+func TestFormatSelectorText(t *testing.T) {
+	var config struct {
+		Format *FormatSelector `json:"format"`
+	}
+
+	if err := json.Unmarshal([]byte(`{"format": "bv[height<=720]+ba/b"}`), &config); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	selection, err := config.Format.Select(selectorFormats())
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	if selection.Format.ItagNo != 136 || selection.Audio.ItagNo != 140 {
+		t.Errorf("selection = %d+%d, want 136+140", selection.Format.ItagNo, selection.Audio.ItagNo)
+	}
+
+	out, err := config.Format.MarshalText()
+	if err != nil || string(out) != "bv[height<=720]+ba/b" {
+		t.Errorf("MarshalText = %s, %v", out, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"format": "bv[height<=]"}`), &config); !errors.Is(err, ErrInvalidSelector) {
+		t.Errorf("invalid selector: err = %v, want ErrInvalidSelector", err)
+	}
+}
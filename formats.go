@@ -4,6 +4,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Format struct {
@@ -26,6 +27,17 @@ type Format struct {
 	AudioSampleRate  string `json:"audioSampleRate"`
 	AudioChannels    int    `json:"audioChannels"`
 
+	// DecipheredURL is the playable stream URL, only set when the video was
+	// fetched WithDecipheredURLs
+	DecipheredURL string `json:"-"`
+
+	// ExpiresAt is when the stream URL stops working
+	ExpiresAt time.Time `json:"-"`
+
+	// Client is the client the format was fetched with, which can differ
+	// between the formats of a video fetched WithMergedFormats
+	Client *YoutubeClient `json:"-"`
+
 	// InitRange is only available for adaptive formats
 	InitRange *struct {
 		Start string `json:"start"`
@@ -53,6 +65,119 @@ func (f *Format) LanguageDisplayName() string {
 	return f.AudioTrack.DisplayName
 }
 
+func (f *Format) audioTrackID() string {
+	if f.AudioTrack == nil {
+		return ""
+	}
+	return f.AudioTrack.ID
+}
+
+// Container returns the container of the format, the subtype of its MIME
+// type: mp4, webm or 3gpp.
+func (f *Format) Container() string {
+	mediaType, _ := parseMimeType(f.MimeType)
+	_, container, _ := strings.Cut(mediaType, "/")
+	return container
+}
+
+// Extension returns the usual file extension of the format, without the
+// dot. Audio only MP4 formats are m4a files.
+func (f *Format) Extension() string {
+	switch container := f.Container(); container {
+	case "mp4":
+		if f.IsAudioOnly() {
+			return "m4a"
+		}
+		return container
+	case "3gpp":
+		return "3gp"
+	default:
+		return container
+	}
+}
+
+// Kind tells whether the format holds video, audio or both.
+func (f *Format) Kind() MediaKind {
+	mediaType, codecs := parseMimeType(f.MimeType)
+	kind, _, _ := strings.Cut(mediaType, "/")
+
+	switch kind {
+	case "audio":
+		return MediaAudio
+	case "video":
+		for i := range codecs {
+			if codecs[i].IsAudio() {
+				return MediaMuxed
+			}
+		}
+		if len(codecs) == 0 && f.AudioChannels > 0 {
+			return MediaMuxed
+		}
+		return MediaVideo
+	}
+
+	return MediaUnknown
+}
+
+// Codecs returns the codecs listed in the MIME type of the format.
+func (f *Format) Codecs() []Codec {
+	_, codecs := parseMimeType(f.MimeType)
+	return codecs
+}
+
+// IsAudioOnly reports whether the format holds audio and no video.
+func (f *Format) IsAudioOnly() bool {
+	return f.Kind() == MediaAudio
+}
+
+// IsVideoOnly reports whether the format holds video and no audio.
+func (f *Format) IsVideoOnly() bool {
+	return f.Kind() == MediaVideo
+}
+
+// VideoCodec returns the video codec of the format, or nil.
+func (f *Format) VideoCodec() *Codec {
+	if kind := f.Kind(); kind != MediaVideo && kind != MediaMuxed {
+		return nil
+	}
+
+	codecs := f.Codecs()
+	for i := range codecs {
+		if codecs[i].IsVideo() {
+			return &codecs[i]
+		}
+	}
+
+	return nil
+}
+
+// AudioCodec returns the audio codec of the format, or nil.
+func (f *Format) AudioCodec() *Codec {
+	kind := f.Kind()
+	if kind != MediaAudio && kind != MediaMuxed {
+		return nil
+	}
+
+	codecs := f.Codecs()
+	for i := range codecs {
+		// audio formats may use codecs unknown to Codec.IsVideo
+		if codecs[i].IsAudio() || kind == MediaAudio {
+			return &codecs[i]
+		}
+	}
+
+	return nil
+}
+
+// HDR reports whether the format is a high dynamic range video.
+func (f *Format) HDR() bool {
+	if c := f.VideoCodec(); c != nil && c.HDR {
+		return true
+	}
+
+	return strings.Contains(f.QualityLabel, "HDR")
+}
+
 type FormatList []Format
 
 // Type returns a new FormatList filtered by itag
@@ -72,10 +197,36 @@ func (list FormatList) Itag(itagNo int) FormatList {
 	})
 }
 
-// Type returns a new FormatList filtered by mime type
+// sameAs returns the format in list matching the itag and audio track of f.
+func (list FormatList) sameAs(f *Format) *Format {
+	for i := range list {
+		if list[i].ItagNo == f.ItagNo && list[i].audioTrackID() == f.audioTrackID() {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+// Type returns a new FormatList filtered by mime type: value is a media
+// type such as video/mp4, video or audio, a file extension such as mp4 or
+// m4a, or a codec family such as opus. As audio only MP4 formats have the
+// m4a extension, Type("mp4") only returns formats with video.
 func (list FormatList) Type(value string) FormatList {
 	return list.Select(func(f Format) bool {
-		return strings.Contains(f.MimeType, value)
+		mediaType, codecs := parseMimeType(f.MimeType)
+		kind, _, _ := strings.Cut(mediaType, "/")
+
+		switch value {
+		case mediaType, kind, f.Extension():
+			return true
+		}
+		for _, c := range codecs {
+			if c.Family == value {
+				return true
+			}
+		}
+
+		return false
 	})
 }
 
@@ -110,86 +261,88 @@ func (list FormatList) WithAudioChannels() FormatList {
 	})
 }
 
-// FilterQuality reduces the format list to formats matching the quality
-func (v *Video) FilterQuality(quality string) {
+// FilterQuality reduces the format list to formats matching the quality,
+// sorted by the rankers as in FormatList.Sort.
+func (v *Video) FilterQuality(quality string, rankers ...FormatRanker) {
 	v.Formats = v.Formats.Quality(quality)
-	v.Formats.Sort()
+	v.Formats.Sort(rankers...)
 }
 
-// Sort sorts all formats fields
-func (list FormatList) Sort() {
+// Sort sorts the formats by the first ranker with a preference, the default
+// ordering breaking the remaining ties.
+func (list FormatList) Sort(rankers ...FormatRanker) {
+	ranker := chainRankers(rankers)
 	sort.SliceStable(list, func(i, j int) bool {
-		return sortFormat(i, j, list)
+		return ranker.Compare(&list[i], &list[j]) < 0
 	})
 }
 
 // sortFormat sorts video by resolution, FPS, codec (av01, vp9, avc1), bitrate
 // sorts audio by default, codec (mp4, opus), channels, bitrate, sample rate
-func sortFormat(i int, j int, formats FormatList) bool {
+func sortFormat(a, b *Format) bool {
 
 	// Sort by Width
-	if formats[i].Width == formats[j].Width {
+	if a.Width == b.Width {
 		// Format 137 downloads slowly, give it less priority
 		// see https://github.com/kkdai/youtube/pull/171
 		switch 137 {
-		case formats[i].ItagNo:
+		case a.ItagNo:
 			return false
-		case formats[j].ItagNo:
+		case b.ItagNo:
 			return true
 		}
 
 		// Sort by FPS
-		if formats[i].FPS == formats[j].FPS {
-			if formats[i].FPS == 0 && formats[i].AudioChannels > 0 && formats[j].AudioChannels > 0 {
+		if a.FPS == b.FPS {
+			if a.IsAudioOnly() && b.IsAudioOnly() {
 				// Audio
 				// Sort by default
-				if (formats[i].AudioTrack == nil && formats[j].AudioTrack == nil) || (formats[i].AudioTrack != nil && formats[j].AudioTrack != nil && formats[i].AudioTrack.AudioIsDefault == formats[j].AudioTrack.AudioIsDefault) {
+				if (a.AudioTrack == nil && b.AudioTrack == nil) || (a.AudioTrack != nil && b.AudioTrack != nil && a.AudioTrack.AudioIsDefault == b.AudioTrack.AudioIsDefault) {
 					// Sort by codec
-					codec := map[int]int{}
-					for _, index := range []int{i, j} {
-						if strings.Contains(formats[index].MimeType, "mp4") {
-							codec[index] = 1
-						} else if strings.Contains(formats[index].MimeType, "opus") {
-							codec[index] = 2
-						}
-					}
-					if codec[i] == codec[j] {
+					codecA := codecRank(a.AudioCodec(), audioCodecRanks)
+					codecB := codecRank(b.AudioCodec(), audioCodecRanks)
+					if codecA == codecB {
 						// Sort by Audio Channel
-						if formats[i].AudioChannels == formats[j].AudioChannels {
+						if a.AudioChannels == b.AudioChannels {
 							// Sort by Audio Bitrate
-							if formats[i].Bitrate == formats[j].Bitrate {
+							if a.Bitrate == b.Bitrate {
 								// Sort by Audio Sample Rate
-								return formats[i].AudioSampleRate > formats[j].AudioSampleRate
+								return a.AudioSampleRate > b.AudioSampleRate
 							}
-							return formats[i].Bitrate > formats[j].Bitrate
+							return a.Bitrate > b.Bitrate
 						}
-						return formats[i].AudioChannels > formats[j].AudioChannels
+						return a.AudioChannels > b.AudioChannels
 					}
-					return codec[i] < codec[j]
-				} else if formats[i].AudioTrack != nil && formats[i].AudioTrack.AudioIsDefault {
+					return codecA < codecB
+				} else if a.AudioTrack != nil && a.AudioTrack.AudioIsDefault {
 					return true
 				}
 				return false
 			}
 			// Video
 			// Sort by codec
-			codec := map[int]int{}
-			for _, index := range []int{i, j} {
-				if strings.Contains(formats[index].MimeType, "av01") {
-					codec[index] = 1
-				} else if strings.Contains(formats[index].MimeType, "vp9") {
-					codec[index] = 2
-				} else if strings.Contains(formats[index].MimeType, "avc1") {
-					codec[index] = 3
-				}
-			}
-			if codec[i] == codec[j] {
+			codecA := codecRank(a.VideoCodec(), videoCodecRanks)
+			codecB := codecRank(b.VideoCodec(), videoCodecRanks)
+			if codecA == codecB {
 				// Sort by Audio Bitrate
-				return formats[i].Bitrate > formats[j].Bitrate
+				return a.Bitrate > b.Bitrate
 			}
-			return codec[i] < codec[j]
+			return codecA < codecB
 		}
-		return formats[i].FPS > formats[j].FPS
+		return a.FPS > b.FPS
+	}
+	return a.Width > b.Width
+}
+
+// codec families in order of preference, unlisted ones coming first
+var (
+	videoCodecRanks = map[string]int{"av01": 1, "vp9": 2, "avc1": 3}
+	audioCodecRanks = map[string]int{"mp4a": 1, "opus": 2}
+)
+
+func codecRank(c *Codec, ranks map[string]int) int {
+	if c == nil {
+		return 0
 	}
-	return formats[i].Width > formats[j].Width
+	return ranks[c.Family]
 }
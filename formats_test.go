@@ -0,0 +1,149 @@
+package youtubedl
+
+import (
+	"testing"
+)
+
+func TestParseCodec(t *testing.T) {
+	tests := []struct {
+		codec string
+		want  Codec
+	}{
+		{"avc1.640028", Codec{Family: "avc1", Profile: "High", Level: "4.0"}},
+		{"avc1.4d401f", Codec{Family: "avc1", Profile: "Main", Level: "3.1"}},
+		{"avc1.42001E", Codec{Family: "avc1", Profile: "Baseline", Level: "3.0"}},
+		{"vp9", Codec{Family: "vp9"}},
+		{"vp09.00.51.08", Codec{Family: "vp9", Profile: "0", Level: "5.1", BitDepth: 8}},
+		{"vp09.02.51.10.01.09.16.09.00", Codec{Family: "vp9", Profile: "2", Level: "5.1", BitDepth: 10, HDR: true}},
+		{"av01.0.12M.08", Codec{Family: "av01", Profile: "0", Level: "5.0", BitDepth: 8}},
+		{"av01.0.13M.10.0.110.09.16.09.0", Codec{Family: "av01", Profile: "0", Level: "5.1", BitDepth: 10, HDR: true}},
+		{"av01.0.09M.10.0.110.01.01.01.0", Codec{Family: "av01", Profile: "0", Level: "4.1", BitDepth: 10}},
+		{"mp4a.40.2", Codec{Family: "mp4a", Profile: "AAC-LC"}},
+		{"mp4a.40.5", Codec{Family: "mp4a", Profile: "HE-AAC"}},
+		{"opus", Codec{Family: "opus"}},
+		{"ec-3", Codec{Family: "ec-3"}},
+	}
+
+	for _, tt := range tests {
+		tt.want.Name = tt.codec
+		if got := ParseCodec(tt.codec); got != tt.want {
+			t.Errorf("ParseCodec(%q) = %+v, want %+v", tt.codec, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMimeType(t *testing.T) {
+	tests := []struct {
+		mimeType   string
+		channels   int
+		container  string
+		ext        string
+		kind       MediaKind
+		videoCodec string
+		audioCodec string
+		hdr        bool
+	}{
+		{`video/mp4; codecs="avc1.42001E, mp4a.40.2"`, 2, "mp4", "mp4", MediaMuxed, "avc1.42001E", "mp4a.40.2", false},
+		{`video/mp4; codecs="avc1.640028"`, 0, "mp4", "mp4", MediaVideo, "avc1.640028", "", false},
+		{`video/webm; codecs="vp09.02.51.10.01.09.16.09.00"`, 0, "webm", "webm", MediaVideo, "vp09.02.51.10.01.09.16.09.00", "", true},
+		{`audio/mp4; codecs="mp4a.40.2"`, 2, "mp4", "m4a", MediaAudio, "", "mp4a.40.2", false},
+		{`audio/webm; codecs="opus"`, 2, "webm", "webm", MediaAudio, "", "opus", false},
+		{`video/3gpp; codecs="mp4v.20.3, mp4a.40.2"`, 1, "3gpp", "3gp", MediaMuxed, "mp4v.20.3", "mp4a.40.2", false},
+		{`video/mp4`, 2, "mp4", "mp4", MediaMuxed, "", "", false},
+		{`video/mp4; codecs="avc1.640028`, 0, "mp4", "mp4", MediaVideo, "", "", false},
+		{``, 0, "", "", MediaUnknown, "", "", false},
+	}
+
+	for _, tt := range tests {
+		f := &Format{MimeType: tt.mimeType, AudioChannels: tt.channels}
+
+		if got := f.Container(); got != tt.container {
+			t.Errorf("%s: Container() = %q, want %q", tt.mimeType, got, tt.container)
+		}
+		if got := f.Extension(); got != tt.ext {
+			t.Errorf("%s: Extension() = %q, want %q", tt.mimeType, got, tt.ext)
+		}
+		if got := f.Kind(); got != tt.kind {
+			t.Errorf("%s: Kind() = %v, want %v", tt.mimeType, got, tt.kind)
+		}
+		if got := f.IsVideoOnly(); got != (tt.kind == MediaVideo) {
+			t.Errorf("%s: IsVideoOnly() = %v", tt.mimeType, got)
+		}
+		if got := f.IsAudioOnly(); got != (tt.kind == MediaAudio) {
+			t.Errorf("%s: IsAudioOnly() = %v", tt.mimeType, got)
+		}
+
+		var video, audio string
+		if c := f.VideoCodec(); c != nil {
+			video = c.Name
+		}
+		if c := f.AudioCodec(); c != nil {
+			audio = c.Name
+		}
+		if video != tt.videoCodec || audio != tt.audioCodec {
+			t.Errorf("%s: codecs = %q and %q, want %q and %q", tt.mimeType, video, audio, tt.videoCodec, tt.audioCodec)
+		}
+		if got := f.HDR(); got != tt.hdr {
+			t.Errorf("%s: HDR() = %v, want %v", tt.mimeType, got, tt.hdr)
+		}
+	}
+
+	if f := (&Format{MimeType: `video/webm; codecs="vp9"`, QualityLabel: "2160p60 HDR"}); !f.HDR() {
+		t.Errorf("HDR quality label: HDR() = false")
+	}
+}
+
+func TestFormatListType(t *testing.T) {
+	formats := selectorFormats()
+
+	itags := func(list FormatList) []int {
+		var out []int
+		for _, f := range list {
+			out = append(out, f.ItagNo)
+		}
+		return out
+	}
+
+	tests := []struct {
+		value string
+		want  []int
+	}{
+		{"mp4", []int{18, 401, 137, 136}},
+		{"m4a", []int{140, 140, 139}},
+		{"webm", []int{248, 251}},
+		{"video/mp4", []int{18, 401, 137, 136}},
+		{"audio/mp4", []int{140, 140, 139}},
+		{"audio", []int{140, 140, 251, 139}},
+		{"video", []int{18, 401, 137, 248, 136}},
+		{"opus", []int{251}},
+		{"avc1", []int{18, 137, 136}},
+		{"mp4a", []int{18, 140, 140, 139}},
+		{"flv", nil},
+	}
+
+	for _, tt := range tests {
+		got := itags(formats.Type(tt.value))
+		if len(got) != len(tt.want) {
+			t.Errorf("Type(%q) = %v, want %v", tt.value, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Type(%q) = %v, want %v", tt.value, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFormatListSort(t *testing.T) {
+	formats := selectorFormats()
+	formats.Sort()
+
+	want := []int{401, 248, 137, 136, 18, 140, 139, 251, 140}
+	for i, f := range formats {
+		if f.ItagNo != want[i] {
+			t.Errorf("sorted formats at %d = %d, want %d", i, f.ItagNo, want[i])
+		}
+	}
+}
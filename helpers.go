@@ -9,6 +9,7 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/exp/rand"
@@ -90,23 +91,53 @@ func httpDo(ctx context.Context, req *http.Request) (*http.Response, error) {
 		Domain: ".youtube.com",
 	})
 
-	res, err := info.Self.httpClient.Do(req)
+	policy := info.Self.getRetryPolicy()
 
-	log := slog.With("method", req.Method, "url", req.URL)
+	for attempt := 1; ; attempt++ {
+		res, err := info.Self.httpClient.Do(req)
 
-	if err == nil && res.StatusCode != http.StatusOK {
-		err = ErrUnexpectedStatusCode(res.StatusCode)
-		res.Body.Close()
-		res = nil
-	}
+		log := slog.With("method", req.Method, "url", req.URL, "attempt", attempt)
+
+		var retryAfter time.Duration
+		if err == nil && res.StatusCode != http.StatusOK {
+			err = ErrUnexpectedStatusCode(res.StatusCode)
+			retryAfter = parseRetryAfter(res.Header.Get("Retry-After"))
+			res.Body.Close()
+			res = nil
+		}
+
+		if err == nil {
+			log.Debug("HTTP request succeeded", "status", res.Status)
+			return res, nil
+		}
 
-	if err != nil {
 		log.Debug("HTTP request failed", "error", err)
-	} else {
-		log.Debug("HTTP request succeeded", "status", res.Status)
-	}
 
-	return res, err
+		if attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return nil, err
+		}
+
+		// the body was consumed by the failed attempt
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, err
+			}
+
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		if hook, ok := ctx.Value(contextKey("retry")).(func(int, error)); ok {
+			hook(attempt, err)
+		}
+
+		if !policy.wait(ctx, attempt, retryAfter) {
+			return nil, err
+		}
+	}
 }
 
 func httpGetBodyBytes(ctx context.Context, url string) ([]byte, error) {
@@ -143,16 +174,64 @@ type chunk struct {
 	data  chan []byte
 }
 
+// chunkBufferPools holds a *sync.Pool of *[]byte for each configured
+// ChunkSize.
+var chunkBufferPools sync.Map
+
+// chunkBuffers hands out the buffers chunks are read into. Buffers of a full
+// chunk come from the pool of the chunk size, the shorter ones of the last
+// chunk or of a range are allocated.
+type chunkBuffers struct {
+	size int64
+	pool *sync.Pool
+}
+
+func newChunkBuffers(chunkSize int64) chunkBuffers {
+	pool, ok := chunkBufferPools.Load(chunkSize)
+	if !ok {
+		pool, _ = chunkBufferPools.LoadOrStore(chunkSize, &sync.Pool{
+			New: func() any {
+				buf := make([]byte, chunkSize)
+				return &buf
+			},
+		})
+	}
+
+	return chunkBuffers{size: chunkSize, pool: pool.(*sync.Pool)}
+}
+
+// get returns a buffer of at least size bytes.
+func (b chunkBuffers) get(size int64) []byte {
+	if size != b.size {
+		return make([]byte, size)
+	}
+	return *b.pool.Get().(*[]byte)
+}
+
+// put gives a buffer obtained from get back.
+func (b chunkBuffers) put(buf []byte) {
+	if int64(cap(buf)) != b.size {
+		return
+	}
+	buf = buf[:cap(buf)]
+	b.pool.Put(&buf)
+}
+
 func getChunks(totalSize, chunkSize int64) []chunk {
+	return getRangeChunks(0, totalSize-1, chunkSize)
+}
+
+// getRangeChunks splits the inclusive byte range from start to end in chunks.
+func getRangeChunks(start, end, chunkSize int64) []chunk {
 	var chunks []chunk
 
-	for start := int64(0); start < totalSize; start += chunkSize {
-		end := chunkSize + start - 1
-		if end > totalSize-1 {
-			end = totalSize - 1
+	for ; start <= end; start += chunkSize {
+		chunkEnd := chunkSize + start - 1
+		if chunkEnd > end {
+			chunkEnd = end
 		}
 
-		chunks = append(chunks, chunk{start, end, make(chan []byte, 1)})
+		chunks = append(chunks, chunk{start, chunkEnd, make(chan []byte, 1)})
 	}
 
 	return chunks
@@ -192,3 +271,15 @@ func RandomUserAgent() string {
 	randomIndex := rand.Intn(len(userAgents))
 	return userAgents[randomIndex]
 }
+
+// readSized reads n bytes whose count comes from the stream itself. The
+// buffer grows as the data arrives rather than trusting n up front, so a
+// corrupt size fails on the missing data instead of allocating it.
+func readSized(r io.Reader, n int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, n))
+	if err == nil && int64(len(data)) < n {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return data, err
+}
@@ -0,0 +1,476 @@
+package youtubedl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// mp4Box is an ISO BMFF box read from a byte slice.
+type mp4Box struct {
+	typ    string
+	offset int64  // of the box header, relative to the parsed slice
+	data   []byte // the payload, after the header
+	size   int64  // of the whole box, header included
+	header int    // length of the header, 8 or 16
+}
+
+// readMP4Boxes returns the boxes stored one after the other in data.
+// A box with size zero extends to the end of data.
+func readMP4Boxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+
+	for offset := int64(0); offset < int64(len(data)); {
+		rest := data[offset:]
+		if len(rest) < 8 {
+			return nil, fmt.Errorf("mp4: truncated box header at offset %d", offset)
+		}
+
+		size := int64(binary.BigEndian.Uint32(rest))
+		typ := string(rest[4:8])
+		header := int64(8)
+
+		switch size {
+		case 0:
+			size = int64(len(rest))
+		case 1:
+			if len(rest) < 16 {
+				return nil, fmt.Errorf("mp4: truncated %s box header at offset %d", typ, offset)
+			}
+			size = int64(binary.BigEndian.Uint64(rest[8:]))
+			header = 16
+		}
+
+		if size < header || size > int64(len(rest)) {
+			return nil, fmt.Errorf("mp4: invalid size %d of %s box at offset %d", size, typ, offset)
+		}
+
+		boxes = append(boxes, mp4Box{
+			typ:    typ,
+			offset: offset,
+			data:   rest[header:size],
+			size:   size,
+			header: int(header),
+		})
+		offset += size
+	}
+
+	return boxes, nil
+}
+
+// findMP4Box returns the first box of type typ in boxes.
+func findMP4Box(boxes []mp4Box, typ string) *mp4Box {
+	for i := range boxes {
+		if boxes[i].typ == typ {
+			return &boxes[i]
+		}
+	}
+
+	return nil
+}
+
+// mp4Reader reads big-endian fields of a box payload. Reads past the end
+// return zero and set err.
+type mp4Reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *mp4Reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.pos+n > len(r.data) {
+		r.err = fmt.Errorf("mp4: box truncated at offset %d", r.pos)
+		return nil
+	}
+
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+
+	return b
+}
+
+func (r *mp4Reader) skip(n int) {
+	r.next(n)
+}
+
+func (r *mp4Reader) u8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *mp4Reader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *mp4Reader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *mp4Reader) u64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// fullBox reads the version and flags of a full box.
+func (r *mp4Reader) fullBox() (version uint8, flags uint32) {
+	v := r.u32()
+	return uint8(v >> 24), v & 0xffffff
+}
+
+// mp4MaxBoxSize bounds the boxes read by mp4Stream, whose sizes come from
+// the stream.
+const mp4MaxBoxSize = 1 << 30
+
+// mp4Stream reads the top-level boxes of a stream one at a time.
+type mp4Stream struct {
+	r      io.Reader
+	offset int64
+	peeked *mp4Box
+}
+
+// next returns the next box, or io.EOF at the end of the stream. Its offset
+// is relative to the start of the stream.
+func (s *mp4Stream) next() (*mp4Box, error) {
+	if box := s.peeked; box != nil {
+		s.peeked = nil
+		return box, nil
+	}
+
+	var header [16]byte
+	if _, err := io.ReadFull(s.r, header[:8]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("mp4: truncated box header at offset %d", s.offset)
+		}
+		return nil, err
+	}
+
+	box := &mp4Box{
+		typ:    string(header[4:8]),
+		offset: s.offset,
+		size:   int64(binary.BigEndian.Uint32(header[:4])),
+		header: 8,
+	}
+
+	switch box.size {
+	case 0:
+		// the box extends to the end of the stream
+		data, err := io.ReadAll(io.LimitReader(s.r, mp4MaxBoxSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > mp4MaxBoxSize {
+			return nil, fmt.Errorf("mp4: %s box at offset %d is too large", box.typ, s.offset)
+		}
+		box.data = data
+		box.size = int64(8 + len(data))
+		s.offset += box.size
+		return box, nil
+	case 1:
+		if _, err := io.ReadFull(s.r, header[8:16]); err != nil {
+			return nil, fmt.Errorf("mp4: truncated %s box header at offset %d", box.typ, s.offset)
+		}
+		box.size = int64(binary.BigEndian.Uint64(header[8:16]))
+		box.header = 16
+	}
+
+	if box.size < int64(box.header) || box.size-int64(box.header) > mp4MaxBoxSize {
+		return nil, fmt.Errorf("mp4: invalid size %d of %s box at offset %d", box.size, box.typ, s.offset)
+	}
+
+	data, err := readSized(s.r, box.size-int64(box.header))
+	if err != nil {
+		return nil, fmt.Errorf("mp4: truncated %s box at offset %d: %w", box.typ, s.offset, err)
+	}
+	box.data = data
+	s.offset += box.size
+
+	return box, nil
+}
+
+// peek returns the next box without consuming it.
+func (s *mp4Stream) peek() (*mp4Box, error) {
+	if s.peeked == nil {
+		box, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		s.peeked = box
+	}
+
+	return s.peeked, nil
+}
+
+// bytes returns the box with its header, which keeps its original width.
+func (b *mp4Box) bytes() []byte {
+	return mp4BoxBytes(b.typ, b.header == 16, b.data)
+}
+
+// mp4BoxBytes returns a box of type typ holding payload.
+func mp4BoxBytes(typ string, large bool, payload ...[]byte) []byte {
+	size := 0
+	for _, p := range payload {
+		size += len(p)
+	}
+
+	var out []byte
+	if large || size+8 > math.MaxUint32 {
+		out = make([]byte, 16, 16+size)
+		binary.BigEndian.PutUint32(out, 1)
+		binary.BigEndian.PutUint64(out[8:], uint64(16+size))
+	} else {
+		out = make([]byte, 8, 8+size)
+		binary.BigEndian.PutUint32(out, uint32(8+size))
+	}
+	copy(out[4:8], typ)
+
+	for _, p := range payload {
+		out = append(out, p...)
+	}
+
+	return out
+}
+
+// mp4Boxes returns the boxes concatenated.
+func mp4Boxes(boxes []mp4Box) []byte {
+	var out []byte
+	for i := range boxes {
+		out = append(out, boxes[i].bytes()...)
+	}
+
+	return out
+}
+
+// mp4Path returns the box found by following the types from boxes down.
+func mp4Path(boxes []mp4Box, types ...string) (*mp4Box, error) {
+	var box *mp4Box
+	for i, typ := range types {
+		if i > 0 {
+			children, err := readMP4Boxes(box.data)
+			if err != nil {
+				return nil, err
+			}
+			boxes = children
+		}
+
+		if box = findMP4Box(boxes, typ); box == nil {
+			return nil, fmt.Errorf("mp4: no %s box", strings.Join(types[:i+1], "/"))
+		}
+	}
+
+	return box, nil
+}
+
+// Track fragment header flags.
+const (
+	tfhdBaseDataOffset    = 0x000001
+	tfhdSampleDescription = 0x000002
+	tfhdDefaultDuration   = 0x000008
+	tfhdDefaultSize       = 0x000010
+	tfhdDefaultFlags      = 0x000020
+	tfhdDefaultBaseIsMoof = 0x020000
+)
+
+// Track run flags.
+const (
+	trunDataOffset       = 0x000001
+	trunFirstSampleFlags = 0x000004
+	trunSampleDuration   = 0x000100
+	trunSampleSize       = 0x000200
+	trunSampleFlags      = 0x000400
+	trunSampleCTO        = 0x000800
+)
+
+// mp4Sample is a sample of a track fragment.
+type mp4Sample struct {
+	offset   int64 // in the stream
+	size     uint32
+	duration uint32
+	flags    uint32
+	cto      int32 // composition time offset
+}
+
+// mp4Fragment is a traf box, with the offsets of its samples resolved.
+type mp4Fragment struct {
+	trackID       uint32
+	decodeTime    uint64
+	hasDecodeTime bool
+	samples       []mp4Sample
+}
+
+// duration returns the sum of the sample durations.
+func (f *mp4Fragment) duration() uint64 {
+	var d uint64
+	for _, s := range f.samples {
+		d += uint64(s.duration)
+	}
+
+	return d
+}
+
+// parseMP4Fragments parses the traf boxes of moof. trex gives the defaults
+// of each track by ID, and may be nil.
+func parseMP4Fragments(moof *mp4Box, trex map[uint32]mp4TrackDefaults) ([]mp4Fragment, error) {
+	children, err := readMP4Boxes(moof.data)
+	if err != nil {
+		return nil, err
+	}
+
+	var fragments []mp4Fragment
+	// without any base flag, data of the next traf follows the previous one
+	next := moof.offset
+
+	for _, traf := range children {
+		if traf.typ != "traf" {
+			continue
+		}
+
+		boxes, err := readMP4Boxes(traf.data)
+		if err != nil {
+			return nil, err
+		}
+
+		tfhd := findMP4Box(boxes, "tfhd")
+		if tfhd == nil {
+			return nil, fmt.Errorf("mp4: traf without tfhd")
+		}
+
+		r := &mp4Reader{data: tfhd.data}
+		_, flags := r.fullBox()
+		f := mp4Fragment{trackID: r.u32()}
+		defaults := trex[f.trackID]
+
+		base := next
+		switch {
+		case flags&tfhdBaseDataOffset != 0:
+			base = int64(r.u64())
+		case flags&tfhdDefaultBaseIsMoof != 0:
+			base = moof.offset
+		}
+		if flags&tfhdSampleDescription != 0 {
+			r.skip(4)
+		}
+		if flags&tfhdDefaultDuration != 0 {
+			defaults.duration = r.u32()
+		}
+		if flags&tfhdDefaultSize != 0 {
+			defaults.size = r.u32()
+		}
+		if flags&tfhdDefaultFlags != 0 {
+			defaults.flags = r.u32()
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		if tfdt := findMP4Box(boxes, "tfdt"); tfdt != nil {
+			r := &mp4Reader{data: tfdt.data}
+			if version, _ := r.fullBox(); version == 1 {
+				f.decodeTime = r.u64()
+			} else {
+				f.decodeTime = uint64(r.u32())
+			}
+			f.hasDecodeTime = r.err == nil
+		}
+
+		offset := base
+		for _, trun := range boxes {
+			if trun.typ != "trun" {
+				continue
+			}
+
+			r := &mp4Reader{data: trun.data}
+			_, flags := r.fullBox()
+			count := r.u32()
+			if flags&trunDataOffset != 0 {
+				offset = base + int64(int32(r.u32()))
+			}
+			firstFlags, hasFirstFlags := uint32(0), flags&trunFirstSampleFlags != 0
+			if hasFirstFlags {
+				firstFlags = r.u32()
+			}
+
+			for i := uint32(0); i < count && r.err == nil; i++ {
+				s := mp4Sample{
+					offset:   offset,
+					duration: defaults.duration,
+					size:     defaults.size,
+					flags:    defaults.flags,
+				}
+				if flags&trunSampleDuration != 0 {
+					s.duration = r.u32()
+				}
+				if flags&trunSampleSize != 0 {
+					s.size = r.u32()
+				}
+				if flags&trunSampleFlags != 0 {
+					s.flags = r.u32()
+				} else if i == 0 && hasFirstFlags {
+					s.flags = firstFlags
+				}
+				if flags&trunSampleCTO != 0 {
+					s.cto = int32(r.u32())
+				}
+
+				f.samples = append(f.samples, s)
+				offset += int64(s.size)
+			}
+
+			if r.err != nil {
+				return nil, r.err
+			}
+		}
+
+		next = offset
+		fragments = append(fragments, f)
+	}
+
+	return fragments, nil
+}
+
+// mp4TrackDefaults are the sample defaults of a trex box.
+type mp4TrackDefaults struct {
+	duration uint32
+	size     uint32
+	flags    uint32
+}
+
+// parseTrex returns the defaults of the trex box.
+func parseTrex(trex *mp4Box) (uint32, mp4TrackDefaults) {
+	r := &mp4Reader{data: trex.data}
+	r.fullBox()
+	trackID := r.u32()
+	r.skip(4) // default_sample_description_index
+
+	return trackID, mp4TrackDefaults{
+		duration: r.u32(),
+		size:     r.u32(),
+		flags:    r.u32(),
+	}
+}
+
+// mdhdTimescale returns the timescale of a mdhd box.
+func mdhdTimescale(mdhd *mp4Box) uint32 {
+	r := &mp4Reader{data: mdhd.data}
+	if version, _ := r.fullBox(); version == 1 {
+		r.skip(16)
+	} else {
+		r.skip(8)
+	}
+
+	return r.u32()
+}
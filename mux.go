@@ -0,0 +1,62 @@
+package youtubedl
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// muxingApp names the library in the files it writes.
+const muxingApp = "github.com/steino/youtubedl"
+
+// DownloadMerged downloads an adaptive video format and an adaptive audio
+// format in parallel and writes them to w as a single file, without any
+// external tool. Both formats must use the same container: MP4 formats
+// give a fragmented MP4 and WebM formats a WebM. As the Cues of a WebM
+// precede its clusters, a merged WebM is spooled whole to a temporary file in
+// os.TempDir before being written to w, which needs room for the output.
+func (c *Client) DownloadMerged(ctx context.Context, video *Video, videoFormat, audioFormat *Format, w io.Writer) error {
+	mux, err := getMuxer(videoFormat, audioFormat)
+	if err != nil {
+		return err
+	}
+
+	videoStream, _, err := c.GetStreamContext(ctx, video, videoFormat)
+	if err != nil {
+		return err
+	}
+	defer videoStream.Close()
+
+	audioStream, _, err := c.GetStreamContext(ctx, video, audioFormat)
+	if err != nil {
+		return err
+	}
+	defer audioStream.Close()
+
+	return mux(w, videoStream, audioStream)
+}
+
+// getMuxer returns the function merging the streams of two formats.
+func getMuxer(videoFormat, audioFormat *Format) (func(w io.Writer, video, audio io.Reader) error, error) {
+	if videoFormat == nil || audioFormat == nil {
+		return nil, ErrNoFormat
+	}
+
+	if !videoFormat.IsVideoOnly() {
+		return nil, fmt.Errorf("itag %d is not a video only format: %s", videoFormat.ItagNo, videoFormat.MimeType)
+	}
+	if !audioFormat.IsAudioOnly() {
+		return nil, fmt.Errorf("itag %d is not an audio only format: %s", audioFormat.ItagNo, audioFormat.MimeType)
+	}
+
+	if container := videoFormat.Container(); container == audioFormat.Container() {
+		switch container {
+		case "mp4":
+			return muxFMP4, nil
+		case "webm":
+			return muxWebM, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: cannot merge %s and %s", ErrUnsupportedContainer, videoFormat.MimeType, audioFormat.MimeType)
+}
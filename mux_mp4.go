@@ -0,0 +1,354 @@
+package youtubedl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// fmp4Input is one of the fragmented MP4 streams merged by muxFMP4.
+type fmp4Input struct {
+	stream    *mp4Stream
+	trackID   uint32 // in the output
+	timescale uint32
+	defaults  map[uint32]mp4TrackDefaults
+
+	// next fragment, nil at the end of the stream
+	moof       *mp4Box
+	boxes      []*mp4Box // following the moof, usually a single mdat
+	decodeTime uint64    // of the next fragment
+	duration   uint64    // of the next fragment
+}
+
+// muxFMP4 merges the single track fragmented MP4 streams video and audio
+// into one fragmented MP4 with the video as track 1 and the audio as
+// track 2. Fragments are interleaved by decode time and the segment
+// indexes of the inputs are dropped.
+func muxFMP4(w io.Writer, video, audio io.Reader) error {
+	inputs := []*fmp4Input{
+		{stream: &mp4Stream{r: video}, trackID: 1},
+		{stream: &mp4Stream{r: audio}, trackID: 2},
+	}
+
+	var ftyp *mp4Box
+	moovs := make([]*mp4Box, len(inputs))
+
+	for i, in := range inputs {
+		// read up to the first fragment
+		for moovs[i] == nil || in.moof == nil {
+			box, err := in.stream.next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			switch box.typ {
+			case "ftyp":
+				if i == 0 {
+					ftyp = box
+				}
+			case "moov":
+				moovs[i] = box
+			case "moof":
+				in.moof = box
+			}
+		}
+
+		if moovs[i] == nil {
+			return fmt.Errorf("mp4: no moov box in input %d", i+1)
+		}
+	}
+
+	moov, err := mergeMoovs(inputs, moovs)
+	if err != nil {
+		return err
+	}
+
+	out := &countingWriter{w: w}
+	if ftyp != nil {
+		if _, err := out.Write(ftyp.bytes()); err != nil {
+			return err
+		}
+	}
+	if _, err := out.Write(moov); err != nil {
+		return err
+	}
+
+	for _, in := range inputs {
+		if err := in.readFragment(); err != nil {
+			return err
+		}
+	}
+
+	for sequence := uint32(1); ; sequence++ {
+		// write the fragment that starts first
+		var in *fmp4Input
+		for _, candidate := range inputs {
+			if candidate.moof == nil {
+				continue
+			}
+			if in == nil || candidate.startsBefore(in) {
+				in = candidate
+			}
+		}
+
+		if in == nil {
+			return nil
+		}
+
+		if err := in.writeFragment(out, sequence); err != nil {
+			return err
+		}
+
+		if err := in.nextFragment(); err != nil {
+			return err
+		}
+	}
+}
+
+// mergeMoovs returns a moov box with the tracks of all inputs, numbered
+// after their trackID, and sets the timescale and defaults of each input.
+func mergeMoovs(inputs []*fmp4Input, moovs []*mp4Box) ([]byte, error) {
+	var children [][]byte
+	var trex [][]byte
+
+	for i, in := range inputs {
+		boxes, err := readMP4Boxes(moovs[i].data)
+		if err != nil {
+			return nil, err
+		}
+
+		traks := 0
+		for _, box := range boxes {
+			switch box.typ {
+			case "mvhd":
+				if i == 0 {
+					mvhd := append([]byte{}, box.data...)
+					setMvhdNextTrackID(mvhd, uint32(len(inputs)+1))
+					children = append(children, mp4BoxBytes("mvhd", false, mvhd))
+				}
+
+			case "trak":
+				traks++
+				trak, err := readMP4Boxes(box.data)
+				if err != nil {
+					return nil, err
+				}
+
+				tkhd := findMP4Box(trak, "tkhd")
+				if tkhd == nil {
+					return nil, fmt.Errorf("mp4: trak without tkhd in input %d", i+1)
+				}
+				setTkhdTrackID(tkhd.data, in.trackID)
+
+				mdhd, err := mp4Path(trak, "mdia", "mdhd")
+				if err != nil {
+					return nil, err
+				}
+				in.timescale = mdhdTimescale(mdhd)
+
+				children = append(children, mp4BoxBytes("trak", box.header == 16, box.data))
+
+			case "mvex":
+				mvex, err := readMP4Boxes(box.data)
+				if err != nil {
+					return nil, err
+				}
+
+				in.defaults = make(map[uint32]mp4TrackDefaults)
+				for _, b := range mvex {
+					if b.typ != "trex" {
+						continue
+					}
+
+					id, defaults := parseTrex(&b)
+					in.defaults[id] = defaults
+
+					// the defaults now apply to the new track ID
+					data := append([]byte{}, b.data...)
+					binary.BigEndian.PutUint32(data[4:8], in.trackID)
+					trex = append(trex, mp4BoxBytes("trex", false, data))
+				}
+
+			default:
+				// keep udta, pssh and the like of the video
+				if i == 0 {
+					children = append(children, box.bytes())
+				}
+			}
+		}
+
+		if traks != 1 {
+			return nil, fmt.Errorf("mp4: input %d has %d tracks, want 1", i+1, traks)
+		}
+		if in.timescale == 0 {
+			return nil, fmt.Errorf("mp4: track of input %d has no timescale", i+1)
+		}
+	}
+
+	children = append(children, mp4BoxBytes("mvex", false, trex...))
+
+	return mp4BoxBytes("moov", false, children...), nil
+}
+
+func setMvhdNextTrackID(mvhd []byte, id uint32) {
+	// next_track_ID is the last field
+	if len(mvhd) >= 4 {
+		binary.BigEndian.PutUint32(mvhd[len(mvhd)-4:], id)
+	}
+}
+
+func setTkhdTrackID(tkhd []byte, id uint32) {
+	offset := 12 // version, flags, creation and modification time
+	if len(tkhd) > 0 && tkhd[0] == 1 {
+		offset = 20
+	}
+
+	if len(tkhd) >= offset+4 {
+		binary.BigEndian.PutUint32(tkhd[offset:], id)
+	}
+}
+
+// readFragment reads the boxes of the fragment started by in.moof, up to
+// the next moof.
+func (in *fmp4Input) readFragment() error {
+	if in.moof == nil {
+		return nil
+	}
+
+	in.boxes = in.boxes[:0]
+	for {
+		box, err := in.stream.peek()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if box.typ == "moof" {
+			break
+		}
+
+		in.stream.next() //nolint:errcheck
+		switch box.typ {
+		case "sidx", "styp", "ssix", "mfra":
+			// indexes and random access data refer to the input layout
+		default:
+			in.boxes = append(in.boxes, box)
+		}
+	}
+
+	fragments, err := parseMP4Fragments(in.moof, in.defaults)
+	if err != nil {
+		return err
+	}
+	if len(fragments) == 0 {
+		return fmt.Errorf("mp4: moof without traf at offset %d", in.moof.offset)
+	}
+
+	if fragments[0].hasDecodeTime {
+		in.decodeTime = fragments[0].decodeTime
+	}
+	in.duration = fragments[0].duration()
+
+	return nil
+}
+
+// nextFragment moves in to the fragment after the current one.
+func (in *fmp4Input) nextFragment() error {
+	// used when the next fragment has no tfdt
+	in.decodeTime += in.duration
+
+	in.moof = nil
+	box, err := in.stream.next()
+	if errors.Is(err, io.EOF) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if box.typ != "moof" {
+		return fmt.Errorf("mp4: unexpected %s box at offset %d", box.typ, box.offset)
+	}
+	in.moof = box
+
+	return in.readFragment()
+}
+
+// startsBefore reports whether the next fragment of in is to be played
+// before the one of other.
+func (in *fmp4Input) startsBefore(other *fmp4Input) bool {
+	// compare the decode times in seconds without losing precision
+	aHi, aLo := bits.Mul64(in.decodeTime, uint64(other.timescale))
+	bHi, bLo := bits.Mul64(other.decodeTime, uint64(in.timescale))
+
+	return aHi < bHi || (aHi == bHi && aLo < bLo)
+}
+
+// writeFragment writes the current fragment of in at the output offset,
+// renumbered to sequence and to the output track ID.
+func (in *fmp4Input) writeFragment(out *countingWriter, sequence uint32) error {
+	shift := out.n - in.moof.offset
+
+	children, err := readMP4Boxes(in.moof.data)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		switch child.typ {
+		case "mfhd":
+			if len(child.data) >= 8 {
+				binary.BigEndian.PutUint32(child.data[4:8], sequence)
+			}
+
+		case "traf":
+			traf, err := readMP4Boxes(child.data)
+			if err != nil {
+				return err
+			}
+
+			tfhd := findMP4Box(traf, "tfhd")
+			if tfhd == nil || len(tfhd.data) < 8 {
+				return fmt.Errorf("mp4: invalid traf at offset %d", in.moof.offset)
+			}
+
+			binary.BigEndian.PutUint32(tfhd.data[4:8], in.trackID)
+
+			// absolute offsets move along with the fragment
+			flags := binary.BigEndian.Uint32(tfhd.data[:4]) & 0xffffff
+			if flags&tfhdBaseDataOffset != 0 && len(tfhd.data) >= 16 {
+				base := int64(binary.BigEndian.Uint64(tfhd.data[8:16]))
+				binary.BigEndian.PutUint64(tfhd.data[8:16], uint64(base+shift))
+			}
+		}
+	}
+
+	// the children were patched in place, the moof keeps its layout
+	if _, err := out.Write(in.moof.bytes()); err != nil {
+		return err
+	}
+
+	for _, box := range in.boxes {
+		if _, err := out.Write(box.bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,281 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fmp4TrackData returns the concatenated samples of each track of a
+// fragmented MP4, and the track IDs of its fragments in order.
+func fmp4TrackData(t *testing.T, data []byte) (map[uint32][]byte, []mp4Fragment) {
+	t.Helper()
+
+	boxes, err := readMP4Boxes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := map[uint32]mp4TrackDefaults{}
+	mvex, err := mp4Path(boxes, "moov", "mvex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, _ := readMP4Boxes(mvex.data)
+	for i := range children {
+		if children[i].typ == "trex" {
+			id, d := parseTrex(&children[i])
+			defaults[id] = d
+		}
+	}
+
+	tracks := map[uint32][]byte{}
+	var fragments []mp4Fragment
+	for i := range boxes {
+		if boxes[i].typ != "moof" {
+			continue
+		}
+
+		frags, err := parseMP4Fragments(&boxes[i], defaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range frags {
+			for _, s := range f.samples {
+				tracks[f.trackID] = append(tracks[f.trackID], data[s.offset:s.offset+int64(s.size)]...)
+			}
+		}
+		fragments = append(fragments, frags...)
+	}
+
+	return tracks, fragments
+}
+
+func TestDownloadMergedMP4(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureMediaID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := client.DownloadMerged(context.Background(), video, &video.Formats.Itag(136)[0], &video.Formats.Itag(140)[0], &out); err != nil {
+		t.Fatalf("DownloadMerged failed: %v", err)
+	}
+
+	boxes, err := readMP4Boxes(out.Bytes())
+	if err != nil {
+		t.Fatalf("output is not a valid MP4: %v", err)
+	}
+
+	var types []string
+	for _, b := range boxes {
+		if len(types) == 0 || types[len(types)-1] != b.typ {
+			types = append(types, b.typ)
+		}
+	}
+	if len(types) < 4 || types[0] != "ftyp" || types[1] != "moov" || types[2] != "moof" {
+		t.Errorf("top-level boxes = %v", types)
+	}
+
+	moov, _ := readMP4Boxes(findMP4Box(boxes, "moov").data)
+	var trackIDs []uint32
+	for _, b := range moov {
+		switch b.typ {
+		case "mvhd":
+			if next := binary.BigEndian.Uint32(b.data[len(b.data)-4:]); next != 3 {
+				t.Errorf("next_track_ID = %d, want 3", next)
+			}
+		case "trak":
+			trak, _ := readMP4Boxes(b.data)
+			trackIDs = append(trackIDs, binary.BigEndian.Uint32(findMP4Box(trak, "tkhd").data[12:]))
+		}
+	}
+	if len(trackIDs) != 2 || trackIDs[0] != 1 || trackIDs[1] != 2 {
+		t.Errorf("track IDs = %v, want [1 2]", trackIDs)
+	}
+
+	checkMergedSamples(t, out.Bytes())
+}
+
+// checkMergedSamples checks that the fragments of merged are interleaved
+// by time and hold the samples of the fixture streams.
+func checkMergedSamples(t *testing.T, merged []byte) {
+	t.Helper()
+
+	tracks, fragments := fmp4TrackData(t, merged)
+
+	timescales := map[uint32]float64{1: 15360, 2: 44100}
+	last := -1.0
+	for i, f := range fragments {
+		if at := float64(f.decodeTime) / timescales[f.trackID]; at < last {
+			t.Errorf("fragment %d of track %d at %v comes after %v", i, f.trackID, at, last)
+		} else {
+			last = at
+		}
+	}
+
+	for id, itag := range map[uint32]string{1: "136", 2: "140"} {
+		data, err := os.ReadFile("testdata/videoplayback/o-fixture2." + itag)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, _ := fmp4TrackData(t, data)
+		if !bytes.Equal(tracks[id], want[1]) {
+			t.Errorf("samples of track %d differ from itag %s", id, itag)
+		}
+	}
+
+	// mfhd sequence numbers are renumbered
+	boxes, _ := readMP4Boxes(merged)
+	sequence := uint32(0)
+	for _, b := range boxes {
+		if b.typ != "moof" {
+			continue
+		}
+		sequence++
+		mfhd, _ := mp4Path([]mp4Box{b}, "moof", "mfhd")
+		if got := binary.BigEndian.Uint32(mfhd.data[4:]); got != sequence {
+			t.Errorf("fragment %d has sequence number %d", sequence, got)
+		}
+	}
+}
+
+// withBaseDataOffsets rewrites the fragments of a fixture to use absolute
+// base data offsets, as some muxers do.
+func withBaseDataOffsets(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	boxes, err := readMP4Boxes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out []byte
+	for _, b := range boxes {
+		if b.typ != "moof" {
+			out = append(out, b.bytes()...)
+			continue
+		}
+
+		build := func(base uint64) []byte {
+			children, _ := readMP4Boxes(append([]byte{}, b.data...))
+			var moof [][]byte
+			for _, child := range children {
+				if child.typ != "traf" {
+					moof = append(moof, child.bytes())
+					continue
+				}
+
+				traf, _ := readMP4Boxes(child.data)
+				var parts [][]byte
+				for _, box := range traf {
+					switch box.typ {
+					case "tfhd":
+						tfhd := binary.BigEndian.AppendUint32(nil, tfhdBaseDataOffset)
+						tfhd = append(tfhd, box.data[4:8]...)
+						tfhd = binary.BigEndian.AppendUint64(tfhd, base)
+						parts = append(parts, mp4BoxBytes("tfhd", false, tfhd))
+					case "trun":
+						binary.BigEndian.PutUint32(box.data[8:12], 0)
+						parts = append(parts, box.bytes())
+					default:
+						parts = append(parts, box.bytes())
+					}
+				}
+				moof = append(moof, mp4BoxBytes("traf", false, parts...))
+			}
+			return mp4BoxBytes("moof", false, moof...)
+		}
+
+		// the samples start after the moof and the mdat header
+		moof := build(0)
+		out = append(out, build(uint64(len(out)+len(moof)+8))...)
+	}
+
+	return out
+}
+
+func TestMuxFMP4BaseDataOffset(t *testing.T) {
+	videoData, err := os.ReadFile("testdata/videoplayback/o-fixture2.136")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioData, err := os.ReadFile("testdata/videoplayback/o-fixture2.140")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audio := withBaseDataOffsets(t, audioData)
+	if tracks, _ := fmp4TrackData(t, audio); !bytes.Equal(tracks[1], func() []byte { d, _ := fmp4TrackData(t, audioData); return d[1] }()) {
+		t.Fatal("rewriting the fixture changed its samples")
+	}
+
+	var out bytes.Buffer
+	if err := muxFMP4(&out, bytes.NewReader(videoData), bytes.NewReader(audio)); err != nil {
+		t.Fatalf("muxFMP4 failed: %v", err)
+	}
+
+	checkMergedSamples(t, out.Bytes())
+}
+
+func TestMP4StreamMalformed(t *testing.T) {
+	for name, data := range map[string]string{
+		"huge 64-bit size":  "\x00\x00\x00\x01moov\x7f\xff\xff\xff\xff\xff\xff\xff",
+		"above the limit":   "\x00\x00\x00\x01mdat\x00\x00\x00\x00\x40\x00\x00\x10",
+		"truncated box":     "\x00\x00\x10\x00moovdata",
+		"size below header": "\x00\x00\x00\x04moov",
+	} {
+		stream := &mp4Stream{r: strings.NewReader(data)}
+		if box, err := stream.next(); err == nil {
+			t.Errorf("%s: read a %s box of %d bytes, want an error", name, box.typ, box.size)
+		}
+	}
+
+	// the muxers fail on such a stream instead of crashing
+	video, err := os.ReadFile("testdata/videoplayback/o-fixture2.136")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := "\x00\x00\x00\x01moov\x7f\xff\xff\xff\xff\xff\xff\xff"
+	if err := muxFMP4(io.Discard, bytes.NewReader(video), strings.NewReader(corrupt)); err == nil {
+		t.Error("muxFMP4 succeeded on a corrupt stream")
+	}
+}
+
+func TestGetMuxer(t *testing.T) {
+	mp4Video := &Format{ItagNo: 136, MimeType: `video/mp4; codecs="avc1.4d401f"`}
+	mp4Audio := &Format{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`}
+	webmVideo := &Format{ItagNo: 247, MimeType: `video/webm; codecs="vp9"`}
+	webmAudio := &Format{ItagNo: 251, MimeType: `audio/webm; codecs="opus"`}
+
+	if _, err := getMuxer(mp4Video, mp4Audio); err != nil {
+		t.Errorf("mp4 + mp4: %v", err)
+	}
+	if _, err := getMuxer(webmVideo, webmAudio); err != nil {
+		t.Errorf("webm + webm: %v", err)
+	}
+	if _, err := getMuxer(mp4Audio, mp4Audio); err == nil {
+		t.Errorf("audio as video: err = nil")
+	}
+	if _, err := getMuxer(mp4Video, mp4Video); err == nil {
+		t.Errorf("video as audio: err = nil")
+	}
+	if _, err := getMuxer(mp4Video, webmAudio); !errors.Is(err, ErrUnsupportedContainer) {
+		t.Errorf("mp4 + webm: err = %v, want ErrUnsupportedContainer", err)
+	}
+	if _, err := getMuxer(mp4Video, nil); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("no audio: err = %v, want ErrNoFormat", err)
+	}
+	if _, err := getMuxer(nil, mp4Audio); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("no video: err = %v, want ErrNoFormat", err)
+	}
+}
@@ -0,0 +1,511 @@
+package youtubedl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// webmMaxClusterDuration starts a new cluster when no video keyframe
+	// came for that long.
+	webmMaxClusterDuration = 5 * time.Second
+
+	// webmPositionWidth is the width of the positions in the SeekHead and
+	// the Cues, fixed so that their size does not depend on their values.
+	webmPositionWidth = 8
+)
+
+// webmInput is one of the WebM streams merged by muxWebM.
+type webmInput struct {
+	stream        *ebmlStream
+	track         uint64 // number in the output
+	timecodeScale uint64
+	duration      time.Duration // from Info
+	trackEntry    []byte        // its children
+	defaultDur    time.Duration
+
+	blocks []webmBlock // of the current cluster
+	done   bool
+	end    time.Duration // of the last block read
+}
+
+// webmBlock is a SimpleBlock or a BlockGroup.
+type webmBlock struct {
+	time     time.Duration
+	keyframe bool
+	group    bool   // a BlockGroup rather than a SimpleBlock
+	data     []byte // of the element
+}
+
+// muxWebM merges the single track WebM streams video and audio into one
+// WebM with the video as track 1 and the audio as track 2. Blocks are
+// interleaved by timestamp in new clusters starting at video keyframes,
+// which the Cues point to. As the Cues and the duration precede the
+// clusters, those are spooled to a temporary file first.
+func muxWebM(w io.Writer, video, audio io.Reader) error {
+	inputs := []*webmInput{
+		{stream: &ebmlStream{r: video}, track: 1},
+		{stream: &ebmlStream{r: audio}, track: 2},
+	}
+
+	var header []byte
+	for i, in := range inputs {
+		h, err := in.readHeaders()
+		if err != nil {
+			return fmt.Errorf("webm: input %d: %w", i+1, err)
+		}
+		if i == 0 {
+			header = h
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "youtubedl-*.webm")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// all timestamps are written in the scale of the video
+	clusters := &webmClusterWriter{
+		w:             &countingWriter{w: tmp},
+		timecodeScale: inputs[0].timecodeScale,
+	}
+
+	for {
+		var next *webmInput
+		for _, in := range inputs {
+			if err := in.fill(); err != nil {
+				return err
+			}
+			if len(in.blocks) == 0 {
+				continue
+			}
+			if next == nil || in.blocks[0].time < next.blocks[0].time {
+				next = in
+			}
+		}
+
+		if next == nil {
+			break
+		}
+
+		block := next.blocks[0]
+		next.blocks = next.blocks[1:]
+
+		if err := clusters.add(next.track, block); err != nil {
+			return err
+		}
+	}
+
+	if err := clusters.flush(); err != nil {
+		return err
+	}
+
+	var duration time.Duration
+	for _, in := range inputs {
+		duration = max(duration, in.duration, in.end)
+	}
+
+	head := webmSegmentHead(inputs, duration, clusters.cues)
+	segmentSize := int64(len(head)) + clusters.w.n
+
+	out := append([]byte{}, header...)
+	out = append(out, ebmlIDBytes(ebmlIDSegment)...)
+	out = append(out, ebmlSizeBytes(uint64(segmentSize), 8)...)
+	out = append(out, head...)
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, tmp)
+
+	return err
+}
+
+// readHeaders reads the stream up to its first cluster and returns its
+// EBML header element.
+func (in *webmInput) readHeaders() ([]byte, error) {
+	e, err := in.stream.next()
+	if err != nil {
+		return nil, err
+	}
+	if e.id != ebmlIDHeader {
+		return nil, fmt.Errorf("not an EBML stream")
+	}
+	if err := in.stream.readData(&e); err != nil {
+		return nil, err
+	}
+	header := e.bytes()
+
+	segment, err := in.stream.next()
+	if err != nil {
+		return nil, err
+	}
+	if segment.id != ebmlIDSegment {
+		return nil, fmt.Errorf("no Segment after the EBML header")
+	}
+
+	in.timecodeScale = 1000000
+
+	for {
+		e, err := in.stream.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if e.id == ebmlIDCluster {
+			// keep the cluster header for fill
+			return header, in.readCluster(e)
+		}
+
+		switch e.id {
+		case ebmlIDInfo, ebmlIDTracks:
+			if err := in.stream.readData(&e); err != nil {
+				return nil, err
+			}
+		default:
+			if err := in.stream.skip(&e); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		children, err := readEBMLElements(e.data)
+		if err != nil {
+			return nil, err
+		}
+
+		if e.id == ebmlIDInfo {
+			if scale := findEBMLElement(children, ebmlIDTimecodeScale); scale != nil {
+				in.timecodeScale = ebmlUint(scale.data)
+			}
+			if d := findEBMLElement(children, ebmlIDDuration); d != nil {
+				in.duration = time.Duration(ebmlFloat(d.data) * float64(in.timecodeScale))
+			}
+			continue
+		}
+
+		entries := 0
+		for _, entry := range children {
+			if entry.id != ebmlIDTrackEntry {
+				continue
+			}
+			entries++
+			in.trackEntry = entry.data
+
+			fields, err := readEBMLElements(entry.data)
+			if err != nil {
+				return nil, err
+			}
+			if d := findEBMLElement(fields, ebmlIDDefaultDur); d != nil {
+				in.defaultDur = time.Duration(ebmlUint(d.data))
+			}
+		}
+		if entries != 1 {
+			return nil, fmt.Errorf("%d tracks, want 1", entries)
+		}
+	}
+}
+
+// fill reads the next cluster once the blocks of the current one are used.
+func (in *webmInput) fill() error {
+	for len(in.blocks) == 0 && !in.done {
+		e, err := in.stream.next()
+		if err == io.EOF {
+			in.done = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if e.id != ebmlIDCluster {
+			if err := in.stream.skip(&e); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := in.readCluster(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readCluster reads the blocks of the cluster whose header is e.
+func (in *webmInput) readCluster(e ebmlElement) error {
+	if in.trackEntry == nil {
+		return fmt.Errorf("webm: Cluster before Tracks at offset %d", e.offset)
+	}
+	if err := in.stream.readData(&e); err != nil {
+		return err
+	}
+
+	children, err := readEBMLElements(e.data)
+	if err != nil {
+		return err
+	}
+
+	var timecode int64
+	if t := findEBMLElement(children, ebmlIDTimecode); t != nil {
+		timecode = int64(ebmlUint(t.data))
+	}
+
+	for _, child := range children {
+		block := webmBlock{data: child.data}
+
+		var header []byte
+		switch child.id {
+		case ebmlIDSimpleBlock:
+			header = child.data
+		case ebmlIDBlockGroup:
+			fields, err := readEBMLElements(child.data)
+			if err != nil {
+				return err
+			}
+			b := findEBMLElement(fields, ebmlIDBlock)
+			if b == nil {
+				return fmt.Errorf("webm: BlockGroup without Block at offset %d", e.offset)
+			}
+			header = b.data
+			block.group = true
+			block.keyframe = findEBMLElement(fields, ebmlIDReferenceBlk) == nil
+
+			if d := findEBMLElement(fields, ebmlIDBlockDuration); d != nil {
+				in.end = max(in.end, in.toDuration(timecode+int64(ebmlUint(d.data))))
+			}
+		default:
+			continue
+		}
+
+		_, width, err := readEBMLVint(header, false)
+		if err != nil || len(header) < width+3 {
+			return fmt.Errorf("webm: invalid block in cluster at offset %d", e.offset)
+		}
+
+		relative := int64(int16(binary.BigEndian.Uint16(header[width:])))
+		block.time = in.toDuration(timecode + relative)
+		if !block.group {
+			block.keyframe = header[width+2]&0x80 != 0
+		}
+
+		in.end = max(in.end, block.time+in.defaultDur)
+		in.blocks = append(in.blocks, block)
+	}
+
+	return nil
+}
+
+func (in *webmInput) toDuration(ticks int64) time.Duration {
+	return time.Duration(ticks * int64(in.timecodeScale))
+}
+
+// webmCue is a cluster starting with a video keyframe.
+type webmCue struct {
+	time     uint64 // in ticks
+	position int64  // relative to the first cluster
+}
+
+// webmClusterWriter groups blocks in clusters.
+type webmClusterWriter struct {
+	w             *countingWriter
+	timecodeScale uint64
+
+	timecode int64 // of the current cluster, in ticks
+	blocks   [][]byte
+	cues     []webmCue
+}
+
+func (cw *webmClusterWriter) add(track uint64, block webmBlock) error {
+	ticks := int64(block.time) / int64(cw.timecodeScale)
+
+	videoKeyframe := track == 1 && block.keyframe
+	tooLong := time.Duration((ticks-cw.timecode)*int64(cw.timecodeScale)) >= webmMaxClusterDuration
+	if len(cw.blocks) == 0 || videoKeyframe || tooLong || ticks-cw.timecode > 0x7fff {
+		if err := cw.flush(); err != nil {
+			return err
+		}
+
+		cw.timecode = ticks
+		if videoKeyframe {
+			cw.cues = append(cw.cues, webmCue{time: uint64(ticks), position: cw.w.n})
+		}
+	}
+
+	data, err := rewriteWebMBlock(block, track, int16(ticks-cw.timecode))
+	if err != nil {
+		return err
+	}
+	cw.blocks = append(cw.blocks, data)
+
+	return nil
+}
+
+// flush writes the current cluster.
+func (cw *webmClusterWriter) flush() error {
+	if len(cw.blocks) == 0 {
+		return nil
+	}
+
+	payload := append([][]byte{ebmlUintBytes(ebmlIDTimecode, uint64(cw.timecode), 0)}, cw.blocks...)
+	cw.blocks = cw.blocks[:0]
+
+	_, err := cw.w.Write(ebmlElementBytes(ebmlIDCluster, payload...))
+
+	return err
+}
+
+// rewriteWebMBlock returns the block element with a new track number and
+// timestamp relative to its cluster.
+func rewriteWebMBlock(block webmBlock, track uint64, relative int16) ([]byte, error) {
+	rewrite := func(data []byte) []byte {
+		_, width, _ := readEBMLVint(data, false)
+		out := ebmlSizeBytes(track, 0)
+		out = binary.BigEndian.AppendUint16(out, uint16(relative))
+		return append(out, data[width+2:]...)
+	}
+
+	if !block.group {
+		return ebmlElementBytes(ebmlIDSimpleBlock, rewrite(block.data)), nil
+	}
+
+	fields, err := readEBMLElements(block.data)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload [][]byte
+	for _, f := range fields {
+		if f.id == ebmlIDBlock {
+			f.data = rewrite(f.data)
+		}
+		payload = append(payload, f.bytes())
+	}
+
+	return ebmlElementBytes(ebmlIDBlockGroup, payload...), nil
+}
+
+// webmBlockFrame returns the frame of a block without lacing, and the
+// duration of its discarded padding.
+func webmBlockFrame(block webmBlock) ([]byte, time.Duration, error) {
+	data := block.data
+	var padding time.Duration
+
+	if block.group {
+		fields, err := readEBMLElements(block.data)
+		if err != nil {
+			return nil, 0, err
+		}
+		if d := findEBMLElement(fields, ebmlIDDiscardPad); d != nil {
+			// a signed integer
+			v := ebmlUint(d.data)
+			if n := len(d.data); n > 0 && n < 8 && d.data[0]&0x80 != 0 {
+				v |= ^uint64(0) << (8 * n)
+			}
+			padding = time.Duration(int64(v))
+		}
+		data = findEBMLElement(fields, ebmlIDBlock).data
+	}
+
+	_, width, err := readEBMLVint(data, false)
+	if err != nil || len(data) < width+3 {
+		return nil, 0, fmt.Errorf("webm: invalid block")
+	}
+	if data[width+2]&0x06 != 0 {
+		return nil, 0, fmt.Errorf("webm: laced blocks are not supported")
+	}
+
+	return data[width+3:], padding, nil
+}
+
+// webmSegmentHead returns the SeekHead, Info, Tracks and Cues preceding
+// the clusters.
+func webmSegmentHead(inputs []*webmInput, duration time.Duration, cues []webmCue) []byte {
+	scale := inputs[0].timecodeScale
+
+	info := ebmlElementBytes(ebmlIDInfo,
+		ebmlUintBytes(ebmlIDTimecodeScale, scale, 0),
+		ebmlStringBytes(ebmlIDMuxingApp, muxingApp),
+		ebmlStringBytes(ebmlIDWritingApp, muxingApp),
+		ebmlFloatBytes(ebmlIDDuration, float64(duration)/float64(scale)),
+	)
+
+	var entries [][]byte
+	for _, in := range inputs {
+		fields, _ := readEBMLElements(in.trackEntry)
+
+		var entry [][]byte
+		for _, f := range fields {
+			switch f.id {
+			case ebmlIDTrackNumber:
+				entry = append(entry, ebmlUintBytes(ebmlIDTrackNumber, in.track, 0))
+			case ebmlIDTrackUID:
+				// UIDs only have to be unique within the file
+				entry = append(entry, ebmlUintBytes(ebmlIDTrackUID, in.track, 0))
+			default:
+				entry = append(entry, f.bytes())
+			}
+		}
+		entries = append(entries, ebmlElementBytes(ebmlIDTrackEntry, entry...))
+	}
+	tracks := ebmlElementBytes(ebmlIDTracks, entries...)
+
+	// the SeekHead and the Cues have fixed sizes, so the positions can be
+	// computed before encoding them
+	seek := func(id uint32, position int64) []byte {
+		return ebmlElementBytes(ebmlIDSeek,
+			ebmlElementBytes(ebmlIDSeekID, ebmlIDBytes(id)),
+			ebmlUintBytes(ebmlIDSeekPosition, uint64(position), webmPositionWidth),
+		)
+	}
+	seekHead := func(infoPosition, tracksPosition, cuesPosition int64) []byte {
+		entries := [][]byte{seek(ebmlIDInfo, infoPosition), seek(ebmlIDTracks, tracksPosition)}
+		// without cues, there is no Cues element to point at
+		if len(cues) > 0 {
+			entries = append(entries, seek(ebmlIDCues, cuesPosition))
+		}
+		return ebmlElementBytes(ebmlIDSeekHead, entries...)
+	}
+
+	cuePoints := func(base int64) [][]byte {
+		var points [][]byte
+		for _, cue := range cues {
+			points = append(points, ebmlElementBytes(ebmlIDCuePoint,
+				ebmlUintBytes(ebmlIDCueTime, cue.time, 0),
+				ebmlElementBytes(ebmlIDCueTrackPos,
+					ebmlUintBytes(ebmlIDCueTrack, 1, 0),
+					ebmlUintBytes(ebmlIDCueClusterPos, uint64(base+cue.position), webmPositionWidth),
+				),
+			))
+		}
+		return points
+	}
+
+	var cuesSize int64
+	if len(cues) > 0 {
+		cuesSize = int64(len(ebmlElementBytes(ebmlIDCues, cuePoints(0)...)))
+	}
+
+	infoPosition := int64(len(seekHead(0, 0, 0)))
+	tracksPosition := infoPosition + int64(len(info))
+	cuesPosition := tracksPosition + int64(len(tracks))
+	clustersPosition := cuesPosition + cuesSize
+
+	var head []byte
+	head = append(head, seekHead(infoPosition, tracksPosition, cuesPosition)...)
+	head = append(head, info...)
+	head = append(head, tracks...)
+	if len(cues) > 0 {
+		head = append(head, ebmlElementBytes(ebmlIDCues, cuePoints(clustersPosition)...)...)
+	}
+
+	return head
+}
@@ -0,0 +1,257 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// webmFrame is a block of a WebM stream.
+type webmFrame struct {
+	track uint64
+	time  int64 // in ticks
+	data  []byte
+}
+
+// webmSegment returns the children of the Segment of a WebM stream and the
+// offset of its data.
+func webmSegment(t *testing.T, data []byte) ([]ebmlElement, int64) {
+	t.Helper()
+
+	top, err := readEBMLElements(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0].id != ebmlIDHeader || top[1].id != ebmlIDSegment {
+		t.Fatalf("top-level elements are not an EBML header and a Segment")
+	}
+	if top[1].size != int64(len(top[1].data)) {
+		t.Errorf("Segment size = %d, want %d", top[1].size, len(top[1].data))
+	}
+
+	children, err := readEBMLElements(top[1].data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return children, top[1].offset + int64(top[1].header)
+}
+
+// webmFrames returns the blocks of the clusters of a WebM stream.
+func webmFrames(t *testing.T, data []byte) []webmFrame {
+	t.Helper()
+
+	children, _ := webmSegment(t, data)
+
+	var frames []webmFrame
+	for _, cluster := range children {
+		if cluster.id != ebmlIDCluster {
+			continue
+		}
+
+		blocks, err := readEBMLElements(cluster.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timecode := int64(ebmlUint(findEBMLElement(blocks, ebmlIDTimecode).data))
+
+		for _, b := range blocks {
+			if b.id != ebmlIDSimpleBlock {
+				continue
+			}
+			track, width, _ := readEBMLVint(b.data, false)
+			frames = append(frames, webmFrame{
+				track: track,
+				time:  timecode + int64(int16(binary.BigEndian.Uint16(b.data[width:]))),
+				data:  b.data[width+3:],
+			})
+		}
+	}
+
+	return frames
+}
+
+func TestDownloadMergedWebM(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureMediaID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := client.DownloadMerged(context.Background(), video, &video.Formats.Itag(247)[0], &video.Formats.Itag(251)[0], &out); err != nil {
+		t.Fatalf("DownloadMerged failed: %v", err)
+	}
+
+	children, segmentStart := webmSegment(t, out.Bytes())
+
+	info, _ := readEBMLElements(findEBMLElement(children, ebmlIDInfo).data)
+	if d := ebmlFloat(findEBMLElement(info, ebmlIDDuration).data); d < 9999 || d > 10001 {
+		t.Errorf("Duration = %v, want 10000", d)
+	}
+
+	tracks, _ := readEBMLElements(findEBMLElement(children, ebmlIDTracks).data)
+	var numbers []uint64
+	for _, entry := range tracks {
+		fields, _ := readEBMLElements(entry.data)
+		numbers = append(numbers, ebmlUint(findEBMLElement(fields, ebmlIDTrackNumber).data))
+	}
+	if len(numbers) != 2 || numbers[0] != 1 || numbers[1] != 2 {
+		t.Errorf("track numbers = %v, want [1 2]", numbers)
+	}
+
+	// every cue points at a cluster, and the seek head at the top elements
+	segment := out.Bytes()[segmentStart:]
+	cues := findEBMLElement(children, ebmlIDCues)
+	if cues == nil {
+		t.Fatal("no Cues")
+	}
+	points, _ := readEBMLElements(cues.data)
+	if len(points) != 10 {
+		t.Errorf("%d cue points, want 10", len(points))
+	}
+	for i, point := range points {
+		fields, _ := readEBMLElements(point.data)
+		positions, _ := readEBMLElements(findEBMLElement(fields, ebmlIDCueTrackPos).data)
+		position := ebmlUint(findEBMLElement(positions, ebmlIDCueClusterPos).data)
+		if !bytes.HasPrefix(segment[position:], ebmlIDBytes(ebmlIDCluster)) {
+			t.Errorf("cue point %d at %d is not a cluster", i, position)
+		}
+	}
+
+	seeks, _ := readEBMLElements(findEBMLElement(children, ebmlIDSeekHead).data)
+	for _, seek := range seeks {
+		fields, _ := readEBMLElements(seek.data)
+		id := findEBMLElement(fields, ebmlIDSeekID).data
+		position := ebmlUint(findEBMLElement(fields, ebmlIDSeekPosition).data)
+		if !bytes.HasPrefix(segment[position:], id) {
+			t.Errorf("seek entry %x at %d points elsewhere", id, position)
+		}
+	}
+
+	frames := webmFrames(t, out.Bytes())
+	for i := 1; i < len(frames); i++ {
+		if frames[i].time < frames[i-1].time {
+			t.Errorf("block %d at %d comes after %d", i, frames[i].time, frames[i-1].time)
+		}
+	}
+
+	for track, itag := range map[uint64]string{1: "247", 2: "251"} {
+		data, err := os.ReadFile("testdata/videoplayback/o-fixture2." + itag)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got, want [][]byte
+		for _, f := range frames {
+			if f.track == track {
+				got = append(got, f.data)
+			}
+		}
+		for _, f := range webmFrames(t, data) {
+			want = append(want, f.data)
+		}
+
+		if len(got) != len(want) {
+			t.Errorf("track %d has %d blocks, want %d", track, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("block %d of track %d differs from itag %s", i, track, itag)
+				break
+			}
+		}
+	}
+}
+
+func TestEBMLStreamMalformed(t *testing.T) {
+	for name, data := range map[string]string{
+		"huge size":       "\x1a\x45\xdf\xa3\x01\x00\xff\xff\xff\xff\xff\xfe",
+		"above the limit": "\x1a\x45\xdf\xa3\x01\x00\x00\x00\x40\x00\x00\x01",
+		"truncated":       "\x1a\x45\xdf\xa3\x88abc",
+	} {
+		stream := &ebmlStream{r: strings.NewReader(data)}
+		e, err := stream.next()
+		if err != nil {
+			t.Fatalf("%s: next failed: %v", name, err)
+		}
+		if err := stream.readData(&e); err == nil {
+			t.Errorf("%s: read an element of %d bytes, want an error", name, len(e.data))
+		}
+	}
+
+	// the remuxers fail on such a stream instead of crashing
+	corrupt := "\x1a\x45\xdf\xa3\x01\x00\xff\xff\xff\xff\xff\xfe"
+	audio, err := os.ReadFile("testdata/videoplayback/o-fixture2.251")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := muxWebM(io.Discard, strings.NewReader(corrupt), bytes.NewReader(audio)); err == nil {
+		t.Error("muxWebM succeeded on a corrupt stream")
+	}
+	if err := remuxOggOpus(io.Discard, strings.NewReader(corrupt), audioTags{}); err == nil {
+		t.Error("remuxOggOpus succeeded on a corrupt stream")
+	}
+}
+
+func TestMuxWebMWithoutCues(t *testing.T) {
+	video, err := os.ReadFile("testdata/videoplayback/o-fixture2.247")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio, err := os.ReadFile("testdata/videoplayback/o-fixture2.251")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// clear the keyframe flags of the video, leaving nothing to cue
+	children, _ := webmSegment(t, video)
+	for _, cluster := range children {
+		if cluster.id != ebmlIDCluster {
+			continue
+		}
+		blocks, _ := readEBMLElements(cluster.data)
+		for _, b := range blocks {
+			if b.id == ebmlIDSimpleBlock {
+				_, width, _ := readEBMLVint(b.data, false)
+				b.data[width+2] &^= 0x80
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := muxWebM(&out, bytes.NewReader(video), bytes.NewReader(audio)); err != nil {
+		t.Fatalf("muxWebM failed: %v", err)
+	}
+
+	children, segmentStart := webmSegment(t, out.Bytes())
+	if findEBMLElement(children, ebmlIDCues) != nil {
+		t.Error("Cues written without cue points")
+	}
+	if len(children) < 4 || children[3].id != ebmlIDCluster {
+		t.Error("the clusters do not follow the tracks")
+	}
+
+	segment := out.Bytes()[segmentStart:]
+	seeks, _ := readEBMLElements(findEBMLElement(children, ebmlIDSeekHead).data)
+	if len(seeks) != 2 {
+		t.Errorf("%d seek entries, want 2", len(seeks))
+	}
+	for _, seek := range seeks {
+		fields, _ := readEBMLElements(seek.data)
+		id := findEBMLElement(fields, ebmlIDSeekID).data
+		position := ebmlUint(findEBMLElement(fields, ebmlIDSeekPosition).data)
+		if bytes.Equal(id, ebmlIDBytes(ebmlIDCues)) {
+			t.Errorf("seek entry for the missing Cues at %d", position)
+		}
+		if !bytes.HasPrefix(segment[position:], id) {
+			t.Errorf("seek entry %x at %d points elsewhere", id, position)
+		}
+	}
+}
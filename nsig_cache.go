@@ -0,0 +1,147 @@
+package youtubedl
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultNSigCacheSize is the number of entries kept by the default NSigCache.
+	DefaultNSigCacheSize = 1024
+
+	// DefaultNSigCacheTTL is how long the default NSigCache keeps an entry.
+	DefaultNSigCacheTTL = 6 * time.Hour
+)
+
+// defaultNSigCache is shared by clients created without WithNSigCache.
+var defaultNSigCache = NewNSigCache(DefaultNSigCacheSize, DefaultNSigCacheTTL)
+
+// NSigCache is a bounded LRU cache of deciphered n parameters, keyed by
+// player ID and input value. It is safe for concurrent use.
+// A nil *NSigCache is valid and caches nothing.
+type NSigCache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[nsigKey]*list.Element
+	lru     *list.List
+	hits    uint64
+	misses  uint64
+}
+
+type nsigKey struct {
+	player string
+	n      string
+}
+
+type nsigEntry struct {
+	key     nsigKey
+	value   string
+	expires time.Time
+}
+
+// NSigCacheStats holds counters of an NSigCache.
+type NSigCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Len    int
+}
+
+// NewNSigCache returns a cache holding at most size entries, each for at
+// most ttl. A ttl of zero keeps entries until they are evicted.
+func NewNSigCache(size int, ttl time.Duration) *NSigCache {
+	if size <= 0 {
+		size = DefaultNSigCacheSize
+	}
+
+	return &NSigCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[nsigKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get returns the deciphered value of n for the given player.
+func (c *NSigCache) Get(player, n string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[nsigKey{player, n}]
+	if !ok {
+		c.misses++
+		return "", false
+	}
+
+	entry := elem.Value.(*nsigEntry)
+	if !entry.expires.IsZero() && c.now().After(entry.expires) {
+		c.remove(elem)
+		c.misses++
+		return "", false
+	}
+
+	c.lru.MoveToFront(elem)
+	c.hits++
+
+	return entry.value, true
+}
+
+// Set stores the deciphered value of n for the given player, evicting the
+// least recently used entry if the cache is full.
+func (c *NSigCache) Set(player, n, value string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = c.now().Add(c.ttl)
+	}
+
+	key := nsigKey{player, n}
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*nsigEntry)
+		entry.value = value
+		entry.expires = expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&nsigEntry{key: key, value: value, expires: expires})
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *NSigCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*nsigEntry).key)
+}
+
+// Stats returns the hit and miss counters and the number of entries.
+func (c *NSigCache) Stats() NSigCacheStats {
+	if c == nil {
+		return NSigCacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return NSigCacheStats{
+		Hits:   c.hits,
+		Misses: c.misses,
+		Len:    c.lru.Len(),
+	}
+}
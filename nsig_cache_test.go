@@ -0,0 +1,82 @@
+package youtubedl
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNSigCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewNSigCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("player1", "a", "A1")
+	c.Set("player2", "a", "A2")
+
+	if v, ok := c.Get("player1", "a"); !ok || v != "A1" {
+		t.Errorf("Get(player1, a) = %q, %v", v, ok)
+	}
+	if v, ok := c.Get("player2", "a"); !ok || v != "A2" {
+		t.Errorf("Get(player2, a) = %q, %v", v, ok)
+	}
+
+	// player1/a was used less recently than player2/a
+	c.Set("player1", "b", "B1")
+	if _, ok := c.Get("player1", "a"); ok {
+		t.Errorf("least recently used entry not evicted")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Get("player1", "b"); ok {
+		t.Errorf("expired entry returned")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Len != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	var disabled *NSigCache
+	disabled.Set("player1", "a", "A1")
+	if _, ok := disabled.Get("player1", "a"); ok {
+		t.Errorf("nil cache returned a value")
+	}
+}
+
+func TestWithNSigCache(t *testing.T) {
+	cache := NewNSigCache(10, 0)
+	client := newTestClient(t, WithNSigCache(cache))
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	format := &video.Formats.Itag(18)[0]
+	for i := 0; i < 2; i++ {
+		stream, err := client.GetStreamURL(video, format)
+		if err != nil {
+			t.Fatalf("GetStreamURL failed: %v", err)
+		}
+
+		uri, _ := url.Parse(stream)
+		if n := uri.Query().Get("n"); n != "hgfedcbaxyz" {
+			t.Errorf("n = %q", n)
+		}
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Len != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	player, err := client.getPlayer(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := cache.Get(player.ID(), "abcdefgh"); !ok || v != "hgfedcbaxyz" {
+		t.Errorf("cache not keyed by player id, got %q, %v", v, ok)
+	}
+}
@@ -1,23 +1,24 @@
 package youtubedl
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"path"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dop251/goja"
 	"github.com/dop251/goja/ast"
 	"github.com/dop251/goja/parser"
 	"github.com/patrickmn/go-cache"
 )
 
 type Player struct {
+	id              string
 	httpClient      *http.Client
 	sig_timestamp   int
 	sig_sc          string
@@ -26,6 +27,7 @@ type Player struct {
 	nsig_check      string
 	visitorData     string
 	global_variable *FindVariableResult
+	vms             vmPool
 }
 
 var (
@@ -34,69 +36,74 @@ var (
 	signatureSourceCodeRe = regexp.MustCompile(`(?m)function\(([A-Za-z_0-9]+)\)\{([A-Za-z_0-9]+=[A-Za-z_0-9]+\.split\((?:[^)]+)\)(.+?)\.join\((?:[^)]+)\))\}`)
 	nsigCheckRe           = regexp.MustCompile(`(?m)if\(typeof (.+)\=\=\=.+\)return`)
 
-	nsigCache   = cache.New(-1, -1)
+	// playerCache holds the *PlayerData of the players parsed by any
+	// client, without their HTTP client and visitor data.
 	playerCache = cache.New(5*time.Minute, 10*time.Minute)
 )
 
-func NewPlayer() (player *Player, err error) {
-	uri, err := url.Parse(URLs.YTBase)
-	if err != nil {
-		return
-	}
+// NewPlayer downloads and parses the current player, using the HTTP client
+// and endpoints from opts.
+func NewPlayer(opts ...ClientOpts) (*Player, error) {
+	return NewPlayerContext(context.Background(), opts...)
+}
 
-	visitorData, err := getVisitorData()
+// NewPlayerContext is like NewPlayer, with a context.
+func NewPlayerContext(ctx context.Context, opts ...ClientOpts) (*Player, error) {
+	optsMap := newClientOptions(opts)
+
+	visitorData, err := getVisitorData(ctx, optsMap.httpClient, optsMap.endpoints)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	player = new(Player)
-	player.httpClient = &http.Client{}
-	player.visitorData = visitorData
-
-	uri.Path = path.Join(uri.Path, "/iframe_api")
+	return newPlayer(ctx, optsMap.httpClient, optsMap.endpoints, visitorData, optsMap.playerStore)
+}
 
-	resp, err := player.httpClient.Get(uri.String())
+func newPlayer(ctx context.Context, httpClient *http.Client, endpoints Endpoints, visitorData string, store PlayerStore) (player *Player, err error) {
+	uri, err := endpoints.url("/iframe_api")
 	if err != nil {
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := playerGet(ctx, httpClient, uri.String())
 	if err != nil {
 		return
 	}
 
-	if !playerRe.Match(body) {
-		return
-	}
-
 	matches := playerRe.FindSubmatch(body)
+	if matches == nil {
+		return nil, ErrPlayerIDNotFound
+	}
 
 	player_id := string(matches[1])
 
-	playerc, found := playerCache.Get(player_id)
-	if found {
-		return playerc.(*Player), nil
-	}
+	player = new(Player)
+	player.id = player_id
+	player.httpClient = httpClient
+	player.visitorData = visitorData
 
-	player_uri, err := url.Parse(URLs.YTBase)
-	if err != nil {
-		return
+	if data, found := playerCache.Get(player_id); found {
+		player.setData(data.(*PlayerData))
+		return player, nil
 	}
 
-	player_uri.Path = path.Join(player_uri.Path, fmt.Sprintf("/s/player/%s/player_ias.vflset/en_US/base.js", player_id))
-	req, err := http.NewRequest("GET", player_uri.String(), nil)
-	if err != nil {
-		return
+	if store != nil {
+		data, err := store.Get(ctx, player_id)
+		if err != nil {
+			slog.Debug("failed to read player from store", "player", player_id, "error", err)
+		} else if data != nil {
+			player.setData(data)
+			playerCache.Set(player_id, data, cache.DefaultExpiration)
+			return player, nil
+		}
 	}
-	req.Header.Add("User-Agent", RandomUserAgent())
 
-	player_resp, err := player.httpClient.Do(req)
+	player_uri, err := endpoints.url(fmt.Sprintf("/s/player/%s/player_ias.vflset/en_US/base.js", player_id))
 	if err != nil {
 		return
 	}
 
-	player_js, err := io.ReadAll(player_resp.Body)
+	player_js, err := playerGet(ctx, httpClient, player_uri.String())
 	if err != nil {
 		return
 	}
@@ -126,12 +133,65 @@ func NewPlayer() (player *Player, err error) {
 		player.nsig_check = nsig_check[1]
 	}
 
-	playerCache.Set(player_id, player, cache.DefaultExpiration)
+	data := player.data()
+	playerCache.Set(player_id, data, cache.DefaultExpiration)
+
+	if store != nil {
+		if err := store.Put(ctx, player_id, data); err != nil {
+			slog.Debug("failed to write player to store", "player", player_id, "error", err)
+		}
+	}
 
 	return
 }
 
-func (p *Player) decipher(uri string, cipher string) (code string, err error) {
+// ID returns the player version, as found in the base.js URL.
+func (p *Player) ID() string {
+	return p.id
+}
+
+func (p *Player) data() *PlayerData {
+	return &PlayerData{
+		SigTimestamp: p.sig_timestamp,
+		SigSC:        p.sig_sc,
+		NSigSC:       p.nsig_sc,
+		NSigName:     p.nsig_name,
+		NSigCheck:    p.nsig_check,
+	}
+}
+
+func (p *Player) setData(data *PlayerData) {
+	p.sig_timestamp = data.SigTimestamp
+	p.sig_sc = data.SigSC
+	p.nsig_sc = data.NSigSC
+	p.nsig_name = data.NSigName
+	p.nsig_check = data.NSigCheck
+}
+
+// playerGet fetches one of the resources the player is built from.
+func playerGet(ctx context.Context, httpClient *http.Client, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("User-Agent", RandomUserAgent())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrUnexpectedStatusCode(resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// decipher returns the playable URL for a format fetched with the source client,
+// caching n parameter transforms in nsigCache, which may be nil.
+func (p *Player) decipher(uri string, cipher string, source *YoutubeClient, nsigCache *NSigCache) (code string, err error) {
 	parsed_uri, err := url.Parse(uri)
 	if err != nil {
 		return
@@ -147,10 +207,7 @@ func (p *Player) decipher(uri string, cipher string) (code string, err error) {
 			return "", err
 		}
 
-		s := query.Get("s")
-		vm := goja.New()
-		vm.Set("sig", s)
-		sig, err := vm.RunString(p.sig_sc)
+		sig, err := p.descrambleSig(query.Get("s"))
 		if err != nil {
 			return "", err
 		}
@@ -158,9 +215,9 @@ func (p *Player) decipher(uri string, cipher string) (code string, err error) {
 		query2 := parsed_uri.Query()
 		sp := query.Get("sp")
 		if sp != "" {
-			query2.Set(sp, sig.String())
+			query2.Set(sp, sig)
 		} else {
-			query2.Set("sig", sig.String())
+			query2.Set("sig", sig)
 		}
 
 		parsed_uri.RawQuery = query2.Encode()
@@ -169,29 +226,16 @@ func (p *Player) decipher(uri string, cipher string) (code string, err error) {
 
 	n := query.Get("n")
 	if p.nsig_sc != "" && n != "" {
-		nsig, found := nsigCache.Get(n)
+		nsig, found := nsigCache.Get(p.id, n)
 		if !found {
-			vm := goja.New()
-			err := vm.Set(p.nsig_check, true)
-			if err != nil {
-				return "", err
-			}
-			_, err = vm.RunString(p.nsig_sc)
-			if err != nil {
-				return "", err
-			}
-
-			var decipher func(string) string
-			err = vm.ExportTo(vm.Get(p.nsig_name), &decipher)
+			nsig, err = p.descrambleN(n)
 			if err != nil {
 				return "", err
 			}
-
-			nsig = decipher(n)
-			nsigCache.Set(n, nsig, -1)
+			nsigCache.Set(p.id, n, nsig)
 		}
 
-		query.Set("n", nsig.(string))
+		query.Set("n", nsig)
 
 	}
 
@@ -213,6 +257,11 @@ func (p *Player) decipher(uri string, cipher string) (code string, err error) {
 		query.Set("cver", Clients["WEB_EMBEDDED"].Version)
 	}
 
+	// the client the format was fetched with knows its version best
+	if source != nil && strings.EqualFold(source.Name, client) {
+		query.Set("cver", source.Version)
+	}
+
 	parsed_uri.RawQuery = query.Encode()
 
 	return parsed_uri.String(), nil
@@ -226,6 +275,9 @@ func extractGlobalVariable(data string) (*FindVariableResult, error) {
 
 func extractSigTimestamp(player_js string) (int, error) {
 	matches := signatureTimestampRe.FindStringSubmatch(player_js)
+	if matches == nil {
+		return 0, ErrSignatureTimestampNotFound
+	}
 
 	sig_timestamp, err := strconv.Atoi(string(matches[1]))
 	if err != nil {
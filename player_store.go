@@ -0,0 +1,105 @@
+package youtubedl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// PlayerData holds the code extracted from a player's base.js.
+type PlayerData struct {
+	SigTimestamp int    `json:"sig_timestamp"`
+	SigSC        string `json:"sig_sc"`
+	NSigSC       string `json:"nsig_sc"`
+	NSigName     string `json:"nsig_name"`
+	NSigCheck    string `json:"nsig_check"`
+}
+
+// PlayerStore persists PlayerData keyed by player ID.
+type PlayerStore interface {
+	// Get returns the data stored for id, or nil if there is none.
+	Get(ctx context.Context, id string) (*PlayerData, error)
+
+	// Put stores data for id.
+	Put(ctx context.Context, id string, data *PlayerData) error
+}
+
+var playerIDRe = regexp.MustCompile(`^\w+$`)
+
+// FilePlayerStore is a PlayerStore keeping one JSON file per player in Dir.
+type FilePlayerStore struct {
+	Dir string
+}
+
+// NewFilePlayerStore returns a FilePlayerStore using dir, which is created
+// on first write.
+func NewFilePlayerStore(dir string) *FilePlayerStore {
+	return &FilePlayerStore{Dir: dir}
+}
+
+func (s *FilePlayerStore) path(id string) (string, error) {
+	if !playerIDRe.MatchString(id) {
+		return "", fmt.Errorf("invalid player id %q", id)
+	}
+
+	return filepath.Join(s.Dir, id+".json"), nil
+}
+
+func (s *FilePlayerStore) Get(_ context.Context, id string) (*PlayerData, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var data PlayerData
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("unable to parse stored player %s: %w", id, err)
+	}
+
+	return &data, nil
+}
+
+func (s *FilePlayerStore) Put(_ context.Context, id string, data *PlayerData) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so concurrent readers never see a partial file
+	f, err := os.CreateTemp(s.Dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
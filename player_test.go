@@ -1,17 +1,27 @@
 package youtubedl
 
 import (
+	"context"
 	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/dop251/goja"
 	"github.com/dop251/goja/parser"
+	"github.com/steino/youtubedl/youtubedltest"
 )
 
 func TestPlayer(t *testing.T) {
-	p, err := NewPlayer()
+	p, err := NewPlayer(testClientOpts(t)...)
 	if err != nil {
-		t.Errorf("failed to create player: %v", err)
+		t.Fatalf("failed to create player: %v", err)
 	}
 
 	if p.sig_sc == "" {
@@ -51,3 +61,194 @@ func TestPlayer(t *testing.T) {
 		t.Errorf("failed to parse p.sig_sc: %v", err)
 	}
 }
+
+func TestFilePlayerStore(t *testing.T) {
+	fixtures := youtubedltest.NewHandler("testdata")
+	baseJSRequests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/base.js") {
+			baseJSRequests++
+		}
+		fixtures.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	store := NewFilePlayerStore(t.TempDir())
+	opts := []ClientOpts{WithHTTPClient(srv.Client()), WithBaseURL(srv.URL), WithPlayerStore(store)}
+
+	playerCache.Flush()
+	p, err := NewPlayer(opts...)
+	if err != nil {
+		t.Fatalf("failed to create player: %v", err)
+	}
+
+	stored, err := store.Get(context.Background(), p.ID())
+	if err != nil || stored == nil {
+		t.Fatalf("player not stored: %v", err)
+	}
+	if *stored != *p.data() {
+		t.Errorf("stored data = %+v, want %+v", stored, p.data())
+	}
+
+	// a new process starts with an empty memory cache
+	playerCache.Flush()
+	p2, err := NewPlayer(opts...)
+	if err != nil {
+		t.Fatalf("failed to create player from store: %v", err)
+	}
+
+	if baseJSRequests != 1 {
+		t.Errorf("base.js downloaded %d times, want 1", baseJSRequests)
+	}
+	if *p2.data() != *p.data() {
+		t.Errorf("player from store = %+v, want %+v", p2.data(), p.data())
+	}
+}
+
+func TestPlayerCacheClients(t *testing.T) {
+	fixtures := youtubedltest.NewHandler("testdata")
+	var baseJSRequests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/base.js") {
+			baseJSRequests.Add(1)
+		}
+		fixtures.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	playerCache.Flush()
+
+	var players []*Player
+	var httpClients []*http.Client
+	for range 2 {
+		httpClient := &http.Client{Transport: srv.Client().Transport}
+		p, err := NewPlayer(WithHTTPClient(httpClient), WithBaseURL(srv.URL))
+		if err != nil {
+			t.Fatalf("failed to create player: %v", err)
+		}
+		players = append(players, p)
+		httpClients = append(httpClients, httpClient)
+	}
+
+	if n := baseJSRequests.Load(); n != 1 {
+		t.Errorf("base.js downloaded %d times, want 1", n)
+	}
+	if *players[1].data() != *players[0].data() {
+		t.Errorf("cached player = %+v, want %+v", players[1].data(), players[0].data())
+	}
+
+	// the parsed player is shared, not the HTTP state of the first client
+	if players[1] == players[0] {
+		t.Fatal("both clients got the same *Player")
+	}
+	for i, p := range players {
+		if p.httpClient != httpClients[i] {
+			t.Errorf("player %d uses the HTTP client of another client", i)
+		}
+	}
+}
+
+func newTestPlayer(tb testing.TB) *Player {
+	tb.Helper()
+
+	srv := youtubedltest.NewServer("testdata")
+	defer srv.Close()
+
+	p, err := NewPlayer(WithHTTPClient(srv.Client()), WithBaseURL(srv.URL))
+	if err != nil {
+		tb.Fatalf("failed to create player: %v", err)
+	}
+
+	return p
+}
+
+func TestDecipherConcurrent(t *testing.T) {
+	p := newTestPlayer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			n := fmt.Sprintf("n%04d", i)
+			got, err := p.descrambleN(n)
+			if err != nil {
+				t.Errorf("descrambleN(%q) failed: %v", n, err)
+			} else if want := reverse(n) + "xyz"; got != want {
+				t.Errorf("descrambleN(%q) = %q, want %q", n, got, want)
+			}
+
+			sig, err := p.descrambleSig("ABCDEFGHIJKLMNOP")
+			if err != nil {
+				t.Errorf("descrambleSig failed: %v", err)
+			} else if sig != "IMLKJNHGFEDCBA" {
+				t.Errorf("descrambleSig = %q", sig)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func reverse(s string) string {
+	b := []byte(s)
+	for l, r := 0, len(b)-1; l < r; l, r = l+1, r-1 {
+		b[l], b[r] = b[r], b[l]
+	}
+	return string(b)
+}
+
+func BenchmarkDescrambleN(b *testing.B) {
+	p := newTestPlayer(b)
+
+	b.Run("pool", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := p.descrambleN(strconv.Itoa(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// the previous implementation, evaluating nsig_sc in a new runtime per call
+	b.Run("runtime-per-call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			vm := goja.New()
+			if err := vm.Set(p.nsig_check, true); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := vm.RunString(p.nsig_sc); err != nil {
+				b.Fatal(err)
+			}
+
+			var decipher func(string) string
+			if err := vm.ExportTo(vm.Get(p.nsig_name), &decipher); err != nil {
+				b.Fatal(err)
+			}
+			decipher(strconv.Itoa(i))
+		}
+	})
+}
+
+func BenchmarkDescrambleSig(b *testing.B) {
+	p := newTestPlayer(b)
+
+	b.Run("pool", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := p.descrambleSig("ABCDEFGHIJKLMNOP"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("runtime-per-call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			vm := goja.New()
+			if err := vm.Set("sig", "ABCDEFGHIJKLMNOP"); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := vm.RunString(p.sig_sc); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -5,8 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/url"
-	"path"
 	"regexp"
 	"runtime/debug"
 	"strconv"
@@ -105,13 +103,12 @@ func (p *Playlist) parsePlaylistInfo(ctx context.Context, body []byte) (err erro
 	p.Videos = entries
 
 	for continuation != "" {
-		data := info.Player.prepareInnertubePlaylistData(continuation, true, info.Client)
+		data := prepareInnertubePlaylistData(continuation, true, info.Client, info.VisitorData)
 
-		base_uri, err := url.Parse(URLs.YTBase)
+		base_uri, err := info.Self.endpoints.url("/youtubei/v1/browse")
 		if err != nil {
 			return err
 		}
-		base_uri.Path = path.Join(base_uri.Path, "/youtubei/v1/browse")
 		if info.Client.APIKey != "" {
 			query := base_uri.Query()
 			query.Add("key", info.Client.APIKey)
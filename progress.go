@@ -0,0 +1,284 @@
+package youtubedl
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// ProgressInterval is the minimum time between two Progress reports made
+// while data is being received.
+var ProgressInterval = 100 * time.Millisecond
+
+// ChunkEvent describes a chunk of a download.
+type ChunkEvent struct {
+	Start   int64 // offset of the first byte
+	End     int64 // offset of the last byte
+	Attempt int   // attempt number, counting from 1
+	Err     error // set for retries and failures
+}
+
+// Progress is a snapshot of a download.
+type Progress struct {
+	// BytesDownloaded includes bytes downloaded by a resumed earlier attempt.
+	BytesDownloaded int64
+
+	// BytesInFlight are requested by running chunks but not received yet.
+	BytesInFlight int64
+
+	// TotalBytes is zero if the length of the stream is unknown.
+	TotalBytes int64
+
+	// ActiveChunks is the number of chunks being downloaded.
+	ActiveChunks int
+
+	// Throughput in bytes per second, averaged since the download started.
+	Throughput float64
+
+	// ETA is zero if it cannot be estimated yet.
+	ETA time.Duration
+}
+
+// DownloadObserver is notified about the chunks and progress of a download.
+// Its methods are called from the download goroutines and should not block.
+type DownloadObserver interface {
+	ChunkStarted(ChunkEvent)
+	ChunkCompleted(ChunkEvent)
+	ChunkRetry(ChunkEvent)
+	ChunkFailed(ChunkEvent)
+	Progress(Progress)
+}
+
+// NopDownloadObserver ignores all events. Embed it to implement only some
+// DownloadObserver methods.
+type NopDownloadObserver struct{}
+
+func (NopDownloadObserver) ChunkStarted(ChunkEvent)   {}
+func (NopDownloadObserver) ChunkCompleted(ChunkEvent) {}
+func (NopDownloadObserver) ChunkRetry(ChunkEvent)     {}
+func (NopDownloadObserver) ChunkFailed(ChunkEvent)    {}
+func (NopDownloadObserver) Progress(Progress)         {}
+
+// ProgressFunc is called with the progress of a download.
+type ProgressFunc func(Progress)
+
+type progressFuncObserver struct {
+	NopDownloadObserver
+	f ProgressFunc
+}
+
+func (o progressFuncObserver) Progress(p Progress) {
+	o.f(p)
+}
+
+// WithProgress calls f with the progress of the download.
+func WithProgress(f ProgressFunc) DownloadOpts {
+	return WithObserver(progressFuncObserver{f: f})
+}
+
+// WithObserver notifies observer about the chunks and progress of the download.
+func WithObserver(observer DownloadObserver) DownloadOpts {
+	return func(o *downloadoptions) {
+		o.observers = append(o.observers, observer)
+	}
+}
+
+// progressTracker aggregates the state of a download for its observers.
+// A nil *progressTracker tracks nothing.
+type progressTracker struct {
+	observers []DownloadObserver
+	now       func() time.Time
+
+	reportMu   sync.Mutex
+	mu         sync.Mutex
+	started    time.Time
+	lastReport time.Time
+	total      int64
+	resumed    int64 // bytes downloaded before this session
+	received   int64 // bytes received in this session
+	inFlight   int64
+	active     int
+}
+
+func newProgressTracker(observers []DownloadObserver, total int64) *progressTracker {
+	if len(observers) == 0 {
+		return nil
+	}
+
+	return &progressTracker{
+		observers: observers,
+		now:       time.Now,
+		started:   time.Now(),
+		total:     total,
+	}
+}
+
+// progressLocked returns the current Progress, with t.mu held.
+func (t *progressTracker) progressLocked() Progress {
+	p := Progress{
+		BytesDownloaded: t.resumed + t.received,
+		BytesInFlight:   t.inFlight,
+		TotalBytes:      t.total,
+		ActiveChunks:    t.active,
+	}
+
+	if elapsed := t.now().Sub(t.started).Seconds(); elapsed > 0 {
+		p.Throughput = float64(t.received) / elapsed
+	}
+
+	if p.Throughput > 0 && t.total > 0 {
+		remaining := float64(t.total - p.BytesDownloaded)
+		p.ETA = time.Duration(remaining / p.Throughput * float64(time.Second))
+	}
+
+	return p
+}
+
+// report sends the current progress to the observers. Unless force is
+// set, it does nothing if the last report is more recent than ProgressInterval.
+func (t *progressTracker) report(force bool) {
+	if t == nil {
+		return
+	}
+
+	// reportMu keeps the reports of concurrent chunks in order.
+	t.reportMu.Lock()
+	defer t.reportMu.Unlock()
+
+	t.mu.Lock()
+	now := t.now()
+	if !force && now.Sub(t.lastReport) < ProgressInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastReport = now
+	p := t.progressLocked()
+	t.mu.Unlock()
+
+	for _, o := range t.observers {
+		o.Progress(p)
+	}
+}
+
+func (t *progressTracker) update(f func()) {
+	t.mu.Lock()
+	f()
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) setTotal(total int64) {
+	if t == nil {
+		return
+	}
+	t.update(func() { t.total = total })
+}
+
+func (t *progressTracker) setResumed(n int64) {
+	if t == nil {
+		return
+	}
+	t.update(func() { t.resumed = n })
+}
+
+func (t *progressTracker) chunkStarted(c *chunk) {
+	if t == nil {
+		return
+	}
+
+	t.update(func() {
+		t.active++
+		t.inFlight += c.end - c.start + 1
+	})
+
+	for _, o := range t.observers {
+		o.ChunkStarted(ChunkEvent{Start: c.start, End: c.end, Attempt: 1})
+	}
+	t.report(true)
+}
+
+func (t *progressTracker) chunkCompleted(c *chunk, attempt int) {
+	if t == nil {
+		return
+	}
+
+	t.update(func() { t.active-- })
+
+	for _, o := range t.observers {
+		o.ChunkCompleted(ChunkEvent{Start: c.start, End: c.end, Attempt: attempt})
+	}
+	t.report(true)
+}
+
+// chunkRetry records a failed attempt of a chunk, whose partial data
+// received so far will be downloaded again.
+func (t *progressTracker) chunkRetry(c *chunk, attempt int, partial int64, err error) {
+	if t == nil {
+		return
+	}
+
+	t.update(func() {
+		t.received -= partial
+		t.inFlight += partial
+	})
+
+	for _, o := range t.observers {
+		o.ChunkRetry(ChunkEvent{Start: c.start, End: c.end, Attempt: attempt, Err: err})
+	}
+	t.report(true)
+}
+
+func (t *progressTracker) chunkFailed(c *chunk, attempt int, partial int64, err error) {
+	if t == nil {
+		return
+	}
+
+	t.update(func() {
+		t.active--
+		t.received -= partial
+		t.inFlight -= c.end - c.start + 1 - partial
+	})
+
+	for _, o := range t.observers {
+		o.ChunkFailed(ChunkEvent{Start: c.start, End: c.end, Attempt: attempt, Err: err})
+	}
+	t.report(true)
+}
+
+// reader counts the bytes read from r as received. The returned counter
+// holds the number of bytes read through this reader.
+func (t *progressTracker) reader(r io.Reader) (io.Reader, *int64) {
+	counter := new(int64)
+	if t == nil {
+		return r, counter
+	}
+
+	return &progressReader{r: r, t: t, n: counter}, counter
+}
+
+type progressReader struct {
+	r io.Reader
+	t *progressTracker
+	n *int64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		*pr.n += int64(n)
+		pr.t.update(func() {
+			pr.t.received += int64(n)
+			if pr.t.inFlight >= int64(n) {
+				pr.t.inFlight -= int64(n)
+			}
+		})
+		pr.t.report(false)
+	}
+	return n, err
+}
+
+// withRetryHook returns a context making httpDo call hook before retrying
+// a request.
+func withRetryHook(ctx context.Context, hook func(attempt int, err error)) context.Context {
+	return context.WithValue(ctx, contextKey("retry"), hook)
+}
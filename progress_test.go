@@ -0,0 +1,238 @@
+package youtubedl
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	mu        sync.Mutex
+	started   []ChunkEvent
+	completed []ChunkEvent
+	retries   []ChunkEvent
+	failed    []ChunkEvent
+	progress  []Progress
+}
+
+func (o *recordingObserver) record(events *[]ChunkEvent, e ChunkEvent) {
+	o.mu.Lock()
+	*events = append(*events, e)
+	o.mu.Unlock()
+}
+
+func (o *recordingObserver) ChunkStarted(e ChunkEvent)   { o.record(&o.started, e) }
+func (o *recordingObserver) ChunkCompleted(e ChunkEvent) { o.record(&o.completed, e) }
+func (o *recordingObserver) ChunkRetry(e ChunkEvent)     { o.record(&o.retries, e) }
+func (o *recordingObserver) ChunkFailed(e ChunkEvent)    { o.record(&o.failed, e) }
+
+func (o *recordingObserver) Progress(p Progress) {
+	o.mu.Lock()
+	o.progress = append(o.progress, p)
+	o.mu.Unlock()
+}
+
+func TestDownloadObserver(t *testing.T) {
+	var client *Client
+	var transport *flakyTransport
+	client, transport = newFlakyClient(t, func(req *http.Request, n int) (*http.Response, error) {
+		if req.URL.Path != "/videoplayback" || n != 1 {
+			return nil, nil
+		}
+
+		switch req.URL.Query().Get("range") {
+		case "0-10239":
+			return statusResponse(req, http.StatusServiceUnavailable, nil), nil
+		case "10240-20479":
+			resp, err := transport.base.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			resp.Body = &truncatedBody{ReadCloser: resp.Body, n: 100}
+			return resp, nil
+		}
+		return nil, nil
+	})
+	client.ChunkSize = 10 * Size1Kb
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	observer := &recordingObserver{}
+	var progress []Progress
+	stream, _, err := client.GetStream(video, &video.Formats.Itag(140)[0],
+		WithObserver(observer),
+		WithProgress(func(p Progress) { progress = append(progress, p) }),
+	)
+	if err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+
+	if _, err := io.ReadAll(stream); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+
+	if len(observer.started) != 4 || len(observer.completed) != 4 {
+		t.Errorf("got %d started and %d completed chunks, want 4", len(observer.started), len(observer.completed))
+	}
+	if len(observer.failed) != 0 {
+		t.Errorf("got failed chunks: %+v", observer.failed)
+	}
+
+	retries := map[int64]ChunkEvent{}
+	for _, e := range observer.retries {
+		retries[e.Start] = e
+	}
+	if len(observer.retries) != 2 || retries[0].Attempt != 1 || retries[10240].Attempt != 1 {
+		t.Errorf("unexpected retries: %+v", observer.retries)
+	}
+	for _, e := range observer.completed {
+		if want := 1; e.Start == 0 || e.Start == 10240 {
+			want = 2
+			if e.Attempt != want {
+				t.Errorf("chunk %d completed at attempt %d, want %d", e.Start, e.Attempt, want)
+			}
+		}
+	}
+
+	if len(progress) != len(observer.progress) {
+		t.Errorf("ProgressFunc called %d times, observer %d times", len(progress), len(observer.progress))
+	}
+
+	last := observer.progress[len(observer.progress)-1]
+	if last.BytesDownloaded != 40000 || last.TotalBytes != 40000 || last.BytesInFlight != 0 || last.ActiveChunks != 0 {
+		t.Errorf("unexpected final progress: %+v", last)
+	}
+	for i := 1; i < len(observer.progress); i++ {
+		if observer.progress[i].BytesDownloaded+observer.progress[i].BytesInFlight > 40000 {
+			t.Errorf("progress exceeds total: %+v", observer.progress[i])
+		}
+	}
+}
+
+// cancelingObserver cancels the download on the first retry.
+type cancelingObserver struct {
+	recordingObserver
+	cancel context.CancelFunc
+}
+
+func (o *cancelingObserver) ChunkRetry(e ChunkEvent) {
+	o.recordingObserver.ChunkRetry(e)
+	o.cancel()
+}
+
+func TestDownloadObserverCanceledRetry(t *testing.T) {
+	var transport *flakyTransport
+	client, transport := newFlakyClient(t, func(req *http.Request, n int) (*http.Response, error) {
+		if req.URL.Query().Get("range") != "0-10239" {
+			return nil, nil
+		}
+		resp, err := transport.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = &truncatedBody{ReadCloser: resp.Body, n: 100}
+		return resp, nil
+	})
+	client.ChunkSize = 10 * Size1Kb
+	client.MaxRoutines = 1
+	// the retry waits until the download is canceled
+	client.RetryPolicy.MinBackoff = time.Hour
+	client.RetryPolicy.MaxBackoff = time.Hour
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	observer := &cancelingObserver{cancel: cancel}
+	stream, _, err := client.GetStreamContext(ctx, video, &video.Formats.Itag(140)[0], WithObserver(observer))
+	if err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+	if _, err := io.ReadAll(stream); err == nil {
+		t.Fatal("read succeeded, want an error")
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+
+	if len(observer.retries) != 1 || observer.retries[0].Attempt != 1 {
+		t.Errorf("unexpected retries: %+v", observer.retries)
+	}
+	if len(observer.failed) != 1 || observer.failed[0].Attempt != 1 {
+		t.Errorf("unexpected failures: %+v, want one at attempt 1", observer.failed)
+	}
+}
+
+func TestProgressTracker(t *testing.T) {
+	observer := &recordingObserver{}
+	tracker := newProgressTracker([]DownloadObserver{observer}, 1000)
+
+	now := tracker.started
+	tracker.now = func() time.Time { return now }
+	tracker.setResumed(200)
+
+	c := &chunk{start: 200, end: 599}
+	tracker.chunkStarted(c)
+
+	now = now.Add(2 * time.Second)
+	r, n := tracker.reader(io.LimitReader(zeroReader{}, 200))
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if *n != 200 {
+		t.Errorf("reader counted %d bytes, want 200", *n)
+	}
+
+	p := observer.progress[len(observer.progress)-1]
+	want := Progress{
+		BytesDownloaded: 400,
+		BytesInFlight:   200,
+		TotalBytes:      1000,
+		ActiveChunks:    1,
+		Throughput:      100,
+		ETA:             6 * time.Second,
+	}
+	if p != want {
+		t.Errorf("progress = %+v, want %+v", p, want)
+	}
+
+	// reports made while reading are throttled
+	reports := len(observer.progress)
+	r, _ = tracker.reader(io.LimitReader(zeroReader{}, 100))
+	io.Copy(io.Discard, r) //nolint:errcheck
+	if len(observer.progress) != reports {
+		t.Errorf("got %d reports within ProgressInterval", len(observer.progress)-reports)
+	}
+
+	// the partial data of a failed attempt is downloaded again
+	tracker.chunkRetry(c, 1, 300, io.ErrUnexpectedEOF)
+	p = observer.progress[len(observer.progress)-1]
+	if p.BytesDownloaded != 200 || p.BytesInFlight != 400 {
+		t.Errorf("after retry: progress = %+v", p)
+	}
+
+	tracker.chunkFailed(c, 2, 0, io.ErrUnexpectedEOF)
+	p = observer.progress[len(observer.progress)-1]
+	if p.ActiveChunks != 0 || p.BytesInFlight != 0 {
+		t.Errorf("after failure: progress = %+v", p)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,172 @@
+package youtubedl
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxRateLimitBurst bounds the bytes a single read may take from the
+// bucket, so that one stream cannot starve the others.
+const maxRateLimitBurst = 32 * Size1Kb
+
+// WithRateLimit limits the throughput of all streams of the client to
+// bytesPerSec. Zero means no limit.
+func WithRateLimit(bytesPerSec int64) ClientOpts {
+	return func(o *clientoptions) {
+		o.rateLimit = bytesPerSec
+	}
+}
+
+// SetRateLimit changes the throughput limit of the client, in bytes per
+// second, shared by all its running and future downloads. Zero removes the
+// limit. Reads already waiting for the old limit are not rescheduled.
+func (c *Client) SetRateLimit(bytesPerSec int64) {
+	c.limiter.setRate(bytesPerSec)
+}
+
+// RateLimit returns the throughput limit of the client in bytes per
+// second, or zero if there is none.
+func (c *Client) RateLimit() int64 {
+	return c.limiter.getRate()
+}
+
+// rateLimiter is a token bucket shared by every download of a client.
+// Callers reserve tokens up front and sleep off any deficit, so concurrent
+// readers are served in the order they asked.
+type rateLimiter struct {
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu     sync.Mutex
+	rate   int64 // bytes per second, zero means unlimited
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	l := &rateLimiter{
+		now:   time.Now,
+		sleep: sleepContext,
+	}
+	l.setRate(bytesPerSec)
+
+	return l
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// burstLocked returns the bucket size, with l.mu held.
+func (l *rateLimiter) burstLocked() int64 {
+	return max(min(l.rate, maxRateLimitBurst), 1)
+}
+
+// advanceLocked adds the tokens earned since the last call, with l.mu held.
+func (l *rateLimiter) advanceLocked() {
+	now := l.now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * float64(l.rate)
+	}
+	l.tokens = min(l.tokens, float64(l.burstLocked()))
+	l.last = now
+}
+
+func (l *rateLimiter) setRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		// start a new limit with a full bucket
+		l.rate = max(bytesPerSec, 0)
+		l.tokens = float64(l.burstLocked())
+		l.last = l.now()
+		return
+	}
+
+	l.advanceLocked()
+	l.rate = max(bytesPerSec, 0)
+	l.tokens = min(l.tokens, float64(l.burstLocked()))
+}
+
+func (l *rateLimiter) getRate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rate
+}
+
+// reserve takes up to n tokens and returns how many were taken and how long
+// the caller has to wait before using them.
+func (l *rateLimiter) reserve(n int) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return n, 0
+	}
+
+	l.advanceLocked()
+	n = int(min(int64(n), l.burstLocked()))
+	l.tokens -= float64(n)
+
+	if l.tokens >= 0 {
+		return n, 0
+	}
+
+	return n, time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// release returns n unused tokens.
+func (l *rateLimiter) release(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return
+	}
+
+	l.tokens = min(l.tokens+float64(n), float64(l.burstLocked()))
+}
+
+// reader limits the reads from r.
+func (l *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	return &rateLimitedReader{ctx: ctx, r: r, l: l}
+}
+
+type rateLimitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+func (rr *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return rr.r.Read(p)
+	}
+
+	n, delay := rr.l.reserve(len(p))
+	if delay > 0 {
+		if err := rr.l.sleep(rr.ctx, delay); err != nil {
+			rr.l.release(n)
+			return 0, err
+		}
+	}
+
+	read, err := rr.r.Read(p[:n])
+	if read < n {
+		rr.l.release(n - read)
+	}
+
+	return read, err
+}
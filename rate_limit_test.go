@@ -0,0 +1,182 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when advanced. Its sleep records the delay and
+// returns at once.
+type fakeClock struct {
+	mu     sync.Mutex
+	t      time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func (c *fakeClock) sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	c.sleeps = append(c.sleeps, d)
+	c.mu.Unlock()
+	return ctx.Err()
+}
+
+func (c *fakeClock) maxSleep() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sleeps) == 0 {
+		return 0
+	}
+	return slices.Max(c.sleeps)
+}
+
+func newFakeRateLimiter(bytesPerSec int64) (*rateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := &rateLimiter{now: clock.now, sleep: clock.sleep}
+	l.setRate(bytesPerSec)
+	return l, clock
+}
+
+func TestRateLimiter(t *testing.T) {
+	l, clock := newFakeRateLimiter(1000)
+
+	steps := []struct {
+		advance time.Duration
+		n       int
+		want    int
+		delay   time.Duration
+	}{
+		{0, 600, 600, 0},                         // from the full bucket
+		{0, 600, 600, 200 * time.Millisecond},    // 200 bytes short
+		{0, 5000, 1000, 1200 * time.Millisecond}, // capped at the burst
+		{2 * time.Second, 100, 100, 0},           // debt paid off
+		{10 * time.Second, 1000, 1000, 0},        // bucket refilled, not beyond burst
+		{0, 1, 1, time.Millisecond},              // bucket empty
+	}
+
+	for i, step := range steps {
+		clock.advance(step.advance)
+		n, delay := l.reserve(step.n)
+		if n != step.want || delay != step.delay {
+			t.Errorf("step %d: reserve(%d) = %d, %v, want %d, %v", i, step.n, n, delay, step.want, step.delay)
+		}
+	}
+
+	// a higher limit pays the remaining debt off faster
+	l.setRate(4000)
+	if _, delay := l.reserve(999); delay != 250*time.Millisecond {
+		t.Errorf("after raising the limit: delay = %v, want 250ms", delay)
+	}
+
+	l.setRate(0)
+	if n, delay := l.reserve(1 << 20); n != 1<<20 || delay != 0 {
+		t.Errorf("unlimited: reserve = %d, %v", n, delay)
+	}
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	l, clock := newFakeRateLimiter(1000)
+	r := l.reader(context.Background(), bytes.NewReader(make([]byte, 2500)))
+
+	// the clock never moves, so every read past the burst adds to the debt
+	buf := make([]byte, 500)
+	for range 5 {
+		if n, err := r.Read(buf); n != 500 || err != nil {
+			t.Fatalf("Read = %d, %v", n, err)
+		}
+	}
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read at EOF = %d, %v", n, err)
+	}
+
+	want := []time.Duration{500 * time.Millisecond, time.Second, 1500 * time.Millisecond, 2 * time.Second}
+	if !slices.Equal(clock.sleeps, want) {
+		t.Errorf("sleeps = %v, want %v", clock.sleeps, want)
+	}
+
+	// reads at EOF or with a canceled context give their tokens back
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.reader(ctx, bytes.NewReader(buf)).Read(buf); err != context.Canceled {
+		t.Errorf("read with canceled context: err = %v", err)
+	}
+	if _, delay := l.reserve(1); delay != 1501*time.Millisecond {
+		t.Errorf("after cancel: delay = %v, want 1.501s", delay)
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	client := newTestClient(t, WithRateLimit(1000))
+	client.ChunkSize = 10 * Size1Kb
+	client.MaxRoutines = 4
+
+	if client.RateLimit() != 1000 {
+		t.Errorf("RateLimit() = %d, want 1000", client.RateLimit())
+	}
+
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	client.limiter.now = clock.now
+	client.limiter.sleep = clock.sleep
+	client.SetRateLimit(2000)
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/videoplayback/o-fixture.140")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two streams share the bucket with all their chunk workers
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			stream, _, err := client.GetStream(video, &video.Formats.Itag(140)[0])
+			if err != nil {
+				t.Errorf("GetStream failed: %v", err)
+				return
+			}
+			defer stream.Close()
+
+			data, err := io.ReadAll(stream)
+			if err != nil {
+				t.Errorf("read failed: %v", err)
+			}
+			if !bytes.Equal(data, want) {
+				t.Errorf("stream content differs from fixture")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// with a frozen clock, the last read waits for all bytes but the
+	// initial burst, plus at most one pending read per worker
+	total := 2 * int64(len(want))
+	minDelay := time.Duration(total-2000) * time.Second / 2000
+	maxDelay := time.Duration(total-2000+8*2000) * time.Second / 2000
+	if got := clock.maxSleep(); got < minDelay || got > maxDelay {
+		t.Errorf("slept at most %v, want between %v and %v", got, minDelay, maxDelay)
+	}
+}
@@ -0,0 +1,141 @@
+package youtubedl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+// RetryPolicy controls how failed requests and chunk downloads are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+
+	// MinBackoff is the delay before the first retry. It doubles with every
+	// following retry, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction, between 0 and 1, of each delay that is randomized.
+	Jitter float64
+
+	// RetryableStatusCodes are the HTTP status codes worth retrying.
+	// Connection resets, timeouts and truncated bodies are always retried.
+	RetryableStatusCodes []int
+}
+
+// DefaultRetryPolicy is used by clients without a RetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	MinBackoff:  500 * time.Millisecond,
+	MaxBackoff:  10 * time.Second,
+	Jitter:      0.2,
+	RetryableStatusCodes: []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+func (c *Client) getRetryPolicy() *RetryPolicy {
+	if c.RetryPolicy != nil {
+		return c.RetryPolicy
+	}
+
+	return &DefaultRetryPolicy
+}
+
+// retryable reports whether a request failing with err is worth retrying.
+func (p *RetryPolicy) retryable(err error) bool {
+	var status ErrUnexpectedStatusCode
+	if errors.As(err, &status) {
+		return slices.Contains(p.RetryableStatusCodes, int(status))
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// backoff returns the delay before retry number attempt, counting from 1.
+// A positive retryAfter, from the Retry-After header, replaces the computed
+// delay. It returns false if the server asks to wait longer than MaxBackoff.
+func (p *RetryPolicy) backoff(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if retryAfter > 0 {
+		return retryAfter, p.MaxBackoff <= 0 || retryAfter <= p.MaxBackoff
+	}
+
+	delay := p.MinBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		delay -= time.Duration(float64(delay) * p.Jitter * rand.Float64()) //nolint:gosec
+	}
+
+	return delay, true
+}
+
+// wait sleeps before retry number attempt, returning false if the request
+// should not be retried after all.
+func (p *RetryPolicy) wait(ctx context.Context, attempt int, retryAfter time.Duration) bool {
+	delay, ok := p.backoff(attempt, retryAfter)
+	if !ok {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
@@ -0,0 +1,262 @@
+package youtubedl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/steino/youtubedl/youtubedltest"
+)
+
+// flakyTransport passes requests to the fixture server, unless fail returns
+// a response or an error for them. n counts the requests to the same URL.
+type flakyTransport struct {
+	base http.RoundTripper
+	fail func(req *http.Request, n int) (*http.Response, error)
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	if t.requests == nil {
+		t.requests = make(map[string]int)
+	}
+	t.requests[req.URL.String()]++
+	n := t.requests[req.URL.String()]
+	t.mu.Unlock()
+
+	if resp, err := t.fail(req, n); resp != nil || err != nil {
+		return resp, err
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// count returns the number of requests to URLs matching f.
+func (t *flakyTransport) count(f func(*url.URL) bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total := 0
+	for u, n := range t.requests {
+		if parsed, _ := url.Parse(u); f(parsed) {
+			total += n
+		}
+	}
+	return total
+}
+
+func newFlakyClient(t *testing.T, fail func(req *http.Request, n int) (*http.Response, error)) (*Client, *flakyTransport) {
+	t.Helper()
+
+	srv := youtubedltest.NewServer("testdata")
+	t.Cleanup(srv.Close)
+
+	transport := &flakyTransport{base: srv.Client().Transport, fail: fail}
+	client, err := New(
+		WithHTTPClient(&http.Client{Transport: transport}),
+		WithEndpoints(Endpoints{Base: srv.URL, Stream: srv.URL}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.RetryPolicy = &RetryPolicy{
+		MaxAttempts:          3,
+		MinBackoff:           time.Millisecond,
+		MaxBackoff:           10 * time.Millisecond,
+		RetryableStatusCodes: DefaultRetryPolicy.RetryableStatusCodes,
+	}
+
+	return client, transport
+}
+
+func statusResponse(req *http.Request, code int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		StatusCode: code,
+		Status:     http.StatusText(code),
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+func isPlayerRequest(u *url.URL) bool {
+	return u.Path == "/youtubei/v1/player"
+}
+
+func TestRetryInnertube(t *testing.T) {
+	client, transport := newFlakyClient(t, func(req *http.Request, n int) (*http.Response, error) {
+		if isPlayerRequest(req.URL) && n <= 2 {
+			return statusResponse(req, http.StatusServiceUnavailable, http.Header{"Retry-After": {"0"}}), nil
+		}
+		return nil, nil
+	})
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+	if video.Title != "Fixture Video" {
+		t.Errorf("video.Title = %q", video.Title)
+	}
+
+	if n := transport.count(isPlayerRequest); n != 3 {
+		t.Errorf("player requested %d times, want 3", n)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		header   http.Header
+		attempts int
+	}{
+		{"not retryable", http.StatusNotFound, nil, 1},
+		{"attempts exhausted", http.StatusBadGateway, nil, 3},
+		{"retry-after too long", http.StatusTooManyRequests, http.Header{"Retry-After": {"3600"}}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, transport := newFlakyClient(t, func(req *http.Request, n int) (*http.Response, error) {
+				if isPlayerRequest(req.URL) {
+					return statusResponse(req, tt.status, tt.header), nil
+				}
+				return nil, nil
+			})
+
+			_, err := client.GetVideo(fixtureVideoID)
+			if !errors.Is(err, ErrUnexpectedStatusCode(tt.status)) {
+				t.Errorf("GetVideo error = %v", err)
+			}
+			if n := transport.count(isPlayerRequest); n != tt.attempts {
+				t.Errorf("player requested %d times, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+// truncatedBody returns n bytes of r, then fails like a dropped connection.
+type truncatedBody struct {
+	io.ReadCloser
+	n int
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n -= n
+	return n, err
+}
+
+func TestRetryChunk(t *testing.T) {
+	const brokenRange = "10240-20479"
+
+	var client *Client
+	var transport *flakyTransport
+	client, transport = newFlakyClient(t, func(req *http.Request, n int) (*http.Response, error) {
+		if req.URL.Path != "/videoplayback" {
+			return nil, nil
+		}
+
+		switch req.URL.Query().Get("range") {
+		case "0-10239":
+			if n == 1 {
+				return nil, syscall.ECONNRESET
+			}
+		case brokenRange:
+			if n == 1 {
+				resp, err := transport.base.RoundTrip(req)
+				if err != nil {
+					return nil, err
+				}
+				resp.Body = &truncatedBody{ReadCloser: resp.Body, n: 100}
+				return resp, nil
+			}
+		}
+		return nil, nil
+	})
+	client.ChunkSize = 10 * Size1Kb
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	stream, _, err := client.GetStream(video, &video.Formats.Itag(140)[0])
+	if err != nil {
+		t.Fatalf("GetStream failed: %v", err)
+	}
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	want, _ := os.ReadFile("testdata/videoplayback/o-fixture.140")
+	if !bytes.Equal(data, want) {
+		t.Errorf("stream content differs from fixture")
+	}
+
+	for r, want := range map[string]int{"0-10239": 2, brokenRange: 2, "20480-30719": 1, "30720-39999": 1} {
+		got := transport.count(func(u *url.URL) bool {
+			return u.Path == "/videoplayback" && u.Query().Get("range") == r
+		})
+		if got != want {
+			t.Errorf("range %s requested %d times, want %d", r, got, want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+		ok         bool
+	}{
+		{1, 0, 100 * time.Millisecond, true},
+		{2, 0, 200 * time.Millisecond, true},
+		{4, 0, 800 * time.Millisecond, true},
+		{5, 0, time.Second, true},
+		{30, 0, time.Second, true},
+		{1, 500 * time.Millisecond, 500 * time.Millisecond, true},
+		{1, 2 * time.Second, 2 * time.Second, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := policy.backoff(tt.attempt, tt.retryAfter)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("backoff(%d, %v) = %v, %v, want %v, %v", tt.attempt, tt.retryAfter, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got, _ := policy.backoff(2, 0); got < 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("backoff with jitter = %v", got)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Segment is a media segment of an adaptive format: a moof and mdat pair
+// in MP4, or a Cluster in WebM.
+type Segment struct {
+	Start    int64 // offset of the first byte in the stream
+	End      int64 // offset of the last byte in the stream
+	Time     time.Duration
+	Duration time.Duration
+}
+
+// SegmentIndex lists the segments of a format, in stream order.
+type SegmentIndex []Segment
+
+// Between returns the segments overlapping the time window from from to to.
+func (idx SegmentIndex) Between(from, to time.Duration) SegmentIndex {
+	first := sort.Search(len(idx), func(i int) bool {
+		return idx[i].Time+idx[i].Duration > from
+	})
+
+	last := first
+	for last < len(idx) && idx[last].Time < to {
+		last++
+	}
+
+	return idx[first:last]
+}
+
+// GetSegmentIndex downloads and parses the index of an adaptive format,
+// the MP4 sidx box or the WebM Cues element.
+func (c *Client) GetSegmentIndex(ctx context.Context, video *Video, format *Format) (SegmentIndex, error) {
+	if format == nil {
+		return nil, ErrNoFormat
+	}
+
+	_, index, err := c.getSegmentIndex(ctx, video, format)
+	return index, err
+}
+
+// GetStreamTimeRange returns the init segment of an adaptive format followed
+// by the segments covering the time window from from to to, along with
+// those segments. As segments start at keyframes, the returned media
+// usually starts a little before from and ends a little after to.
+func (c *Client) GetStreamTimeRange(ctx context.Context, video *Video, format *Format, from, to time.Duration) (io.ReadCloser, SegmentIndex, error) {
+	if format == nil {
+		return nil, nil, ErrNoFormat
+	}
+
+	init, index, err := c.getSegmentIndex(ctx, video, format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	segments := index.Between(from, to)
+	if len(segments) == 0 || from >= to {
+		return nil, nil, fmt.Errorf("%w: %v-%v", ErrNoSegmentsInRange, from, to)
+	}
+
+	stream, err := c.GetStreamRange(ctx, video, format, segments[0].Start, segments[len(segments)-1].End)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(init), stream), stream}, segments, nil
+}
+
+// getSegmentIndex returns the init segment and the parsed index of a format.
+func (c *Client) getSegmentIndex(ctx context.Context, video *Video, format *Format) ([]byte, SegmentIndex, error) {
+	initStart, initEnd, err := format.initRange()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexStart, indexEnd, err := format.indexRange()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var init, index []byte
+	if initEnd+1 == indexStart {
+		// the index follows the init segment, get both at once
+		data, err := c.getStreamBytes(ctx, video, format, initStart, indexEnd)
+		if err != nil {
+			return nil, nil, err
+		}
+		init, index = data[:indexStart-initStart], data[indexStart-initStart:]
+	} else {
+		if init, err = c.getStreamBytes(ctx, video, format, initStart, initEnd); err != nil {
+			return nil, nil, err
+		}
+		if index, err = c.getStreamBytes(ctx, video, format, indexStart, indexEnd); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var segments SegmentIndex
+	switch format.Container() {
+	case "mp4":
+		segments, err = parseSidx(index, indexStart)
+	case "webm":
+		segments, err = parseWebMCues(init, index, format.ContentLength)
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedContainer, format.MimeType)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return init, segments, nil
+}
+
+// parseSidx parses the sidx box found in data, which starts at offset in
+// the stream.
+func parseSidx(data []byte, offset int64) (SegmentIndex, error) {
+	boxes, err := readMP4Boxes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sidx := findMP4Box(boxes, "sidx")
+	if sidx == nil {
+		return nil, fmt.Errorf("mp4: no sidx box in index")
+	}
+
+	r := &mp4Reader{data: sidx.data}
+	version, _ := r.fullBox()
+	r.skip(4) // reference_ID
+	timescale := r.u32()
+
+	var earliest, firstOffset uint64
+	if version == 0 {
+		earliest, firstOffset = uint64(r.u32()), uint64(r.u32())
+	} else {
+		earliest, firstOffset = r.u64(), r.u64()
+	}
+
+	r.skip(2) // reserved
+	count := int(r.u16())
+	if r.err != nil {
+		return nil, r.err
+	}
+	if timescale == 0 {
+		return nil, fmt.Errorf("mp4: sidx timescale is zero")
+	}
+
+	toDuration := func(t uint64) time.Duration {
+		return time.Duration(t * uint64(time.Second) / uint64(timescale))
+	}
+
+	// offsets are relative to the first byte after the sidx box
+	start := offset + sidx.offset + sidx.size + int64(firstOffset)
+	t := earliest
+
+	segments := make(SegmentIndex, 0, count)
+	for range count {
+		ref := r.u32()
+		duration := uint64(r.u32())
+		r.skip(4) // SAP
+
+		if ref>>31 != 0 {
+			return nil, fmt.Errorf("mp4: hierarchical sidx is not supported")
+		}
+
+		size := int64(ref & 0x7fffffff)
+		segments = append(segments, Segment{
+			Start:    start,
+			End:      start + size - 1,
+			Time:     toDuration(t),
+			Duration: toDuration(t+duration) - toDuration(t),
+		})
+
+		start += size
+		t += duration
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return segments, nil
+}
+
+// parseWebMCues parses the Cues element in index. The init segment gives
+// the timecode scale, the duration and the offset cluster positions are
+// relative to.
+func parseWebMCues(init, index []byte, contentLength int64) (SegmentIndex, error) {
+	top, err := readEBMLElements(init)
+	if err != nil {
+		return nil, err
+	}
+
+	segment := findEBMLElement(top, ebmlIDSegment)
+	if segment == nil {
+		return nil, fmt.Errorf("webm: no Segment in init segment")
+	}
+	segmentStart := segment.offset + int64(segment.header)
+
+	children, err := readEBMLElements(segment.data)
+	if err != nil {
+		return nil, err
+	}
+
+	timecodeScale := uint64(1000000)
+	var duration time.Duration
+
+	if info := findEBMLElement(children, ebmlIDInfo); info != nil {
+		fields, err := readEBMLElements(info.data)
+		if err != nil {
+			return nil, err
+		}
+		if e := findEBMLElement(fields, ebmlIDTimecodeScale); e != nil {
+			timecodeScale = ebmlUint(e.data)
+		}
+		if e := findEBMLElement(fields, ebmlIDDuration); e != nil {
+			duration = time.Duration(ebmlFloat(e.data) * float64(timecodeScale))
+		}
+	}
+
+	elements, err := readEBMLElements(index)
+	if err != nil {
+		return nil, err
+	}
+
+	cues := findEBMLElement(elements, ebmlIDCues)
+	if cues == nil {
+		return nil, fmt.Errorf("webm: no Cues in index")
+	}
+
+	points, err := readEBMLElements(cues.data)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments SegmentIndex
+	for _, point := range points {
+		if point.id != ebmlIDCuePoint {
+			continue
+		}
+
+		fields, err := readEBMLElements(point.data)
+		if err != nil {
+			return nil, err
+		}
+
+		cueTime := findEBMLElement(fields, ebmlIDCueTime)
+		positions := findEBMLElement(fields, ebmlIDCueTrackPos)
+		if cueTime == nil || positions == nil {
+			continue
+		}
+
+		fields, err = readEBMLElements(positions.data)
+		if err != nil {
+			return nil, err
+		}
+
+		position := findEBMLElement(fields, ebmlIDCueClusterPos)
+		if position == nil {
+			continue
+		}
+
+		start := segmentStart + int64(ebmlUint(position.data))
+		if n := len(segments); n > 0 && segments[n-1].Start == start {
+			// another track in the same cluster
+			continue
+		}
+
+		segments = append(segments, Segment{
+			Start: start,
+			Time:  time.Duration(ebmlUint(cueTime.data) * timecodeScale),
+		})
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("webm: no cue points in index")
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Start < segments[j].Start
+	})
+
+	for i := range segments {
+		if i+1 < len(segments) {
+			segments[i].End = segments[i+1].Start - 1
+			segments[i].Duration = segments[i+1].Time - segments[i].Time
+			continue
+		}
+
+		// the last cluster extends to the end of the stream
+		switch {
+		case contentLength > 0:
+			segments[i].End = contentLength - 1
+		case segment.size != ebmlUnknownSize:
+			segments[i].End = segmentStart + segment.size - 1
+		default:
+			return nil, fmt.Errorf("webm: unknown end of the last cluster")
+		}
+		if duration > segments[i].Time {
+			segments[i].Duration = duration - segments[i].Time
+		}
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,143 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetSegmentIndex(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureMediaID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	for itag, magic := range map[int][]byte{
+		136: []byte("moof"),
+		140: []byte("moof"),
+		247: {0x1f, 0x43, 0xb6, 0x75},
+		251: {0x1f, 0x43, 0xb6, 0x75},
+	} {
+		format := &video.Formats.Itag(itag)[0]
+		data, err := os.ReadFile("testdata/videoplayback/o-fixture2." + strconv.Itoa(itag))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		index, err := client.GetSegmentIndex(context.Background(), video, format)
+		if err != nil {
+			t.Fatalf("itag %d: GetSegmentIndex failed: %v", itag, err)
+		}
+
+		if len(index) != 10 {
+			t.Fatalf("itag %d: %d segments, want 10", itag, len(index))
+		}
+
+		_, indexEnd, _ := format.indexRange()
+		if index[0].Start != indexEnd+1 || index[9].End != int64(len(data))-1 {
+			t.Errorf("itag %d: segments span %d-%d", itag, index[0].Start, index[9].End)
+		}
+
+		for i, s := range index {
+			if i > 0 && (s.Start != index[i-1].End+1 || s.Time != index[i-1].Time+index[i-1].Duration) {
+				t.Errorf("itag %d: segment %d %+v does not follow %+v", itag, i, s, index[i-1])
+			}
+			if s.Duration < 900*time.Millisecond || s.Duration > 1100*time.Millisecond {
+				t.Errorf("itag %d: segment %d duration = %v", itag, i, s.Duration)
+			}
+
+			// mp4 segments start with moof, webm segments with a Cluster
+			header := data[s.Start : s.Start+8]
+			if !bytes.Contains(header, magic) {
+				t.Errorf("itag %d: segment %d starts with %x", itag, i, header)
+			}
+		}
+	}
+}
+
+func TestGetStreamTimeRange(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureMediaID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	for _, itag := range []int{136, 247} {
+		format := &video.Formats.Itag(itag)[0]
+		data, err := os.ReadFile("testdata/videoplayback/o-fixture2." + strconv.Itoa(itag))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stream, segments, err := client.GetStreamTimeRange(context.Background(), video, format, 3500*time.Millisecond, 5200*time.Millisecond)
+		if err != nil {
+			t.Fatalf("itag %d: GetStreamTimeRange failed: %v", itag, err)
+		}
+
+		got, err := io.ReadAll(stream)
+		stream.Close()
+		if err != nil {
+			t.Fatalf("itag %d: read failed: %v", itag, err)
+		}
+
+		if len(segments) != 3 || segments[0].Time != 3*time.Second {
+			t.Fatalf("itag %d: segments = %+v", itag, segments)
+		}
+
+		_, initEnd, _ := format.initRange()
+		want := append(append([]byte{}, data[:initEnd+1]...), data[segments[0].Start:segments[2].End+1]...)
+		if !bytes.Equal(got, want) {
+			t.Errorf("itag %d: content differs from init segment and segments", itag)
+		}
+	}
+
+	format := &video.Formats.Itag(251)[0]
+	if _, _, err := client.GetStreamTimeRange(context.Background(), video, format, 20*time.Second, 30*time.Second); !errors.Is(err, ErrNoSegmentsInRange) {
+		t.Errorf("window past the end: err = %v, want ErrNoSegmentsInRange", err)
+	}
+
+	if _, _, err := client.GetStreamTimeRange(context.Background(), video, nil, 0, time.Second); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("nil format: err = %v, want ErrNoFormat", err)
+	}
+	if _, err := client.GetSegmentIndex(context.Background(), video, nil); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("GetSegmentIndex(nil): err = %v, want ErrNoFormat", err)
+	}
+}
+
+func TestSegmentIndexBetween(t *testing.T) {
+	var index SegmentIndex
+	for i := range 5 {
+		index = append(index, Segment{Time: time.Duration(i) * time.Second, Duration: time.Second})
+	}
+
+	tests := []struct {
+		from, to    time.Duration
+		first, last time.Duration
+		n           int
+	}{
+		{0, 5 * time.Second, 0, 4 * time.Second, 5},
+		{1500 * time.Millisecond, 2500 * time.Millisecond, time.Second, 2 * time.Second, 2},
+		{2 * time.Second, 3 * time.Second, 2 * time.Second, 2 * time.Second, 1},
+		{4900 * time.Millisecond, time.Minute, 4 * time.Second, 4 * time.Second, 1},
+		{5 * time.Second, time.Minute, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		got := index.Between(tt.from, tt.to)
+		if len(got) != tt.n {
+			t.Errorf("Between(%v, %v) returned %d segments, want %d", tt.from, tt.to, len(got), tt.n)
+			continue
+		}
+		if tt.n > 0 && (got[0].Time != tt.first || got[len(got)-1].Time != tt.last) {
+			t.Errorf("Between(%v, %v) = %v-%v, want %v-%v", tt.from, tt.to, got[0].Time, got[len(got)-1].Time, tt.first, tt.last)
+		}
+	}
+}
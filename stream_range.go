@@ -0,0 +1,115 @@
+package youtubedl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// GetStreamRange returns the bytes from start to end, both inclusive, of a
+// format. Ranges larger than ChunkSize are downloaded in chunks like GetStream.
+func (c *Client) GetStreamRange(ctx context.Context, video *Video, format *Format, start, end int64, opts ...DownloadOpts) (io.ReadCloser, error) {
+	if format == nil {
+		return nil, ErrNoFormat
+	}
+
+	if start < 0 || end < start || (format.ContentLength > 0 && end >= format.ContentLength) {
+		return nil, fmt.Errorf("%w: %d-%d", ErrInvalidRange, start, end)
+	}
+
+	optsMap := downloadoptions{}
+	for _, opt := range opts {
+		opt(&optsMap)
+	}
+
+	cinfo := contextInfo{
+		Self:   c,
+		Player: nil,
+		Client: video.formatClient(format),
+	}
+
+	ctx = context.WithValue(ctx, contextKey("info"), cinfo)
+	src := c.newStreamSource(video, format)
+	if _, _, err := src.get(ctx); err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	tracker := newProgressTracker(optsMap.observers, end-start+1)
+	c.downloadChunked(ctx, src, w, getRangeChunks(start, end, c.getChunkSize()), tracker)
+
+	return r, nil
+}
+
+// GetInitSegment returns the initialization segment of an adaptive format,
+// which holds the MP4 moov box or the WebM headers.
+func (c *Client) GetInitSegment(ctx context.Context, video *Video, format *Format) ([]byte, error) {
+	if format == nil {
+		return nil, ErrNoFormat
+	}
+
+	start, end, err := format.initRange()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.getStreamBytes(ctx, video, format, start, end)
+}
+
+// GetIndexSegment returns the segment index of an adaptive format, which is
+// the MP4 sidx box or the WebM Cues element.
+func (c *Client) GetIndexSegment(ctx context.Context, video *Video, format *Format) ([]byte, error) {
+	if format == nil {
+		return nil, ErrNoFormat
+	}
+
+	start, end, err := format.indexRange()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.getStreamBytes(ctx, video, format, start, end)
+}
+
+func (c *Client) getStreamBytes(ctx context.Context, video *Video, format *Format, start, end int64) ([]byte, error) {
+	stream, err := c.GetStreamRange(ctx, video, format, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	return io.ReadAll(stream)
+}
+
+// initRange returns the inclusive byte range of the init segment.
+func (f *Format) initRange() (int64, int64, error) {
+	if f.InitRange == nil {
+		return 0, 0, ErrNoInitRange
+	}
+
+	return parseByteRange(f.InitRange.Start, f.InitRange.End)
+}
+
+// indexRange returns the inclusive byte range of the index segment.
+func (f *Format) indexRange() (int64, int64, error) {
+	if f.IndexRange == nil {
+		return 0, 0, ErrNoIndexRange
+	}
+
+	return parseByteRange(f.IndexRange.Start, f.IndexRange.End)
+}
+
+func parseByteRange(start, end string) (int64, int64, error) {
+	s, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %q-%q", ErrInvalidRange, start, end)
+	}
+
+	e, err := strconv.ParseInt(end, 10, 64)
+	if err != nil || e < s {
+		return 0, 0, fmt.Errorf("%w: %q-%q", ErrInvalidRange, start, end)
+	}
+
+	return s, e, nil
+}
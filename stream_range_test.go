@@ -0,0 +1,112 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestGetStreamRange(t *testing.T) {
+	client := newTestClient(t)
+	client.ChunkSize = 10 * Size1Kb
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+	format := &video.Formats.Itag(137)[0]
+
+	want, err := os.ReadFile("testdata/videoplayback/o-fixture.137")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pools := map[int64]bool{}
+	chunkBufferPools.Range(func(size, _ any) bool {
+		pools[size.(int64)] = true
+		return true
+	})
+
+	for _, r := range [][2]int64{{0, 0}, {100, 199}, {5000, 34999}, {110000, 119999}} {
+		stream, err := client.GetStreamRange(context.Background(), video, format, r[0], r[1])
+		if err != nil {
+			t.Fatalf("GetStreamRange(%d, %d) failed: %v", r[0], r[1], err)
+		}
+
+		data, err := io.ReadAll(stream)
+		stream.Close()
+		if err != nil {
+			t.Fatalf("GetStreamRange(%d, %d): read failed: %v", r[0], r[1], err)
+		}
+		if !bytes.Equal(data, want[r[0]:r[1]+1]) {
+			t.Errorf("GetStreamRange(%d, %d): content differs from fixture", r[0], r[1])
+		}
+	}
+
+	for _, r := range [][2]int64{{-1, 10}, {10, 9}, {0, 120000}} {
+		if _, err := client.GetStreamRange(context.Background(), video, format, r[0], r[1]); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("GetStreamRange(%d, %d): err = %v, want ErrInvalidRange", r[0], r[1], err)
+		}
+	}
+
+	if _, err := client.GetStreamRange(context.Background(), video, nil, 0, 10); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("nil format: err = %v, want ErrNoFormat", err)
+	}
+
+	chunkBufferPools.Range(func(size, _ any) bool {
+		if !pools[size.(int64)] && size.(int64) != client.ChunkSize {
+			t.Errorf("buffer pool for chunks of %d bytes, want only ChunkSize", size)
+		}
+		return true
+	})
+}
+
+func TestGetSegments(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	want, err := os.ReadFile("testdata/videoplayback/o-fixture.140")
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := &video.Formats.Itag(140)[0]
+
+	init, err := client.GetInitSegment(context.Background(), video, format)
+	if err != nil {
+		t.Fatalf("GetInitSegment failed: %v", err)
+	}
+	if !bytes.Equal(init, want[:632]) {
+		t.Errorf("init segment differs from fixture")
+	}
+
+	index, err := client.GetIndexSegment(context.Background(), video, format)
+	if err != nil {
+		t.Fatalf("GetIndexSegment failed: %v", err)
+	}
+	if !bytes.Equal(index, want[632:928]) {
+		t.Errorf("index segment differs from fixture")
+	}
+
+	// progressive formats have neither
+	progressive := &video.Formats.Itag(18)[0]
+	if _, err := client.GetInitSegment(context.Background(), video, progressive); !errors.Is(err, ErrNoInitRange) {
+		t.Errorf("GetInitSegment: err = %v, want ErrNoInitRange", err)
+	}
+	if _, err := client.GetIndexSegment(context.Background(), video, progressive); !errors.Is(err, ErrNoIndexRange) {
+		t.Errorf("GetIndexSegment: err = %v, want ErrNoIndexRange", err)
+	}
+
+	if _, err := client.GetInitSegment(context.Background(), video, nil); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("GetInitSegment(nil): err = %v, want ErrNoFormat", err)
+	}
+	if _, err := client.GetIndexSegment(context.Background(), video, nil); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("GetIndexSegment(nil): err = %v, want ErrNoFormat", err)
+	}
+}
@@ -0,0 +1,272 @@
+package youtubedl
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// streamBlockSize is the default size of the range requests of a StreamReader.
+	streamBlockSize = Size1Mb
+
+	// streamCacheBlocks is the number of blocks a StreamReader keeps.
+	streamCacheBlocks = 8
+
+	// streamReadAhead is the number of blocks fetched ahead of sequential reads.
+	streamReadAhead = 2
+)
+
+// StreamReader reads a format with range requests made on demand, so it can
+// seek and serve random reads, for example through http.ServeContent.
+// Sequential reads fetch the following blocks ahead of time and recently
+// read blocks are cached. ReadAt may be called concurrently; Read and Seek
+// share an offset and may not.
+type StreamReader struct {
+	client    *Client
+	src       *streamSource
+	size      int64
+	blockSize int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	offset int64 // used by Read and Seek
+	closed atomic.Bool
+
+	mu     sync.Mutex
+	blocks map[int64]*list.Element // of *streamBlock, by index
+	lru    *list.List              // most recently used first
+}
+
+var (
+	_ io.ReadSeekCloser = (*StreamReader)(nil)
+	_ io.ReaderAt       = (*StreamReader)(nil)
+)
+
+type streamBlock struct {
+	index int64
+	done  chan struct{} // closed once data or err is set
+	data  []byte
+	err   error
+}
+
+// OpenStream returns a StreamReader for a format. Its size is the content
+// length of the format, or if that is unknown, the one sent by googlevideo.
+// The context applies to all reads until Close.
+func (c *Client) OpenStream(ctx context.Context, video *Video, format *Format) (*StreamReader, error) {
+	if format == nil {
+		return nil, ErrNoFormat
+	}
+
+	cinfo := contextInfo{
+		Self:   c,
+		Player: nil,
+		Client: video.formatClient(format),
+	}
+
+	ctx = context.WithValue(ctx, contextKey("info"), cinfo)
+	src := c.newStreamSource(video, format)
+	if _, _, err := src.get(ctx); err != nil {
+		return nil, err
+	}
+
+	size := format.ContentLength
+	if size == 0 {
+		// only the headers are needed, not the whole stream
+		resp, err := src.request(ctx, http.MethodHead, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		size, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return nil, ErrContentLengthUnknown
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &StreamReader{
+		client:    c,
+		src:       src,
+		size:      size,
+		blockSize: streamBlockSize,
+		ctx:       ctx,
+		cancel:    cancel,
+		blocks:    make(map[int64]*list.Element),
+		lru:       list.New(),
+	}, nil
+}
+
+// Size returns the length of the stream.
+func (r *StreamReader) Size() int64 {
+	return r.size
+}
+
+// Read implements io.Reader.
+func (r *StreamReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	// read at most up to the end of the current block, so that reads
+	// return as soon as a block arrives
+	index := r.offset / r.blockSize
+	end := min((index+1)*r.blockSize, r.size)
+	if int64(len(p)) > end-r.offset {
+		p = p[:end-r.offset]
+	}
+
+	for i := index + 1; i <= index+streamReadAhead && i*r.blockSize < r.size; i++ {
+		r.block(i)
+	}
+
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *StreamReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("StreamReader.ReadAt: negative offset")
+	}
+
+	if r.closed.Load() {
+		return 0, ErrStreamClosed
+	}
+
+	n := 0
+	for n < len(p) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+
+		block := r.block(off / r.blockSize)
+		select {
+		case <-block.done:
+		case <-r.ctx.Done():
+			return n, r.closedErr()
+		}
+
+		if block.err != nil {
+			r.evict(block)
+			return n, block.err
+		}
+
+		copied := copy(p[n:], block.data[off-block.index*r.blockSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+// Seek implements io.Seeker.
+func (r *StreamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("StreamReader.Seek: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("StreamReader.Seek: negative position")
+	}
+
+	r.offset = offset
+
+	return offset, nil
+}
+
+// Close stops all requests of the reader and drops its cache.
+func (r *StreamReader) Close() error {
+	r.closed.Store(true)
+	r.cancel()
+
+	r.mu.Lock()
+	r.blocks = make(map[int64]*list.Element)
+	r.lru.Init()
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *StreamReader) closedErr() error {
+	if r.closed.Load() {
+		return ErrStreamClosed
+	}
+
+	return r.ctx.Err()
+}
+
+// block returns the block at index, starting its download if it is not cached.
+func (r *StreamReader) block(index int64) *streamBlock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.blocks[index]; ok {
+		r.lru.MoveToFront(elem)
+		return elem.Value.(*streamBlock)
+	}
+
+	block := &streamBlock{index: index, done: make(chan struct{})}
+	r.blocks[index] = r.lru.PushFront(block)
+
+	for r.lru.Len() > streamCacheBlocks {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.blocks, oldest.Value.(*streamBlock).index)
+	}
+
+	go r.fetch(block)
+
+	return block
+}
+
+// evict drops a failed block, so that the next read requests it again.
+func (r *StreamReader) evict(block *streamBlock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.blocks[block.index]; ok && elem.Value == block {
+		r.lru.Remove(elem)
+		delete(r.blocks, block.index)
+	}
+}
+
+func (r *StreamReader) fetch(block *streamBlock) {
+	defer close(block.done)
+
+	start := block.index * r.blockSize
+	c := &chunk{
+		start: start,
+		end:   min(start+r.blockSize, r.size) - 1,
+		data:  make(chan []byte, 1),
+	}
+
+	if err := r.client.downloadChunk(r.ctx, r.src, c, make([]byte, c.end-c.start+1), nil); err != nil {
+		if r.ctx.Err() != nil {
+			err = r.closedErr()
+		}
+		block.err = err
+		return
+	}
+
+	block.data = <-c.data
+}
@@ -0,0 +1,214 @@
+package youtubedl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/steino/youtubedl/youtubedltest"
+)
+
+func openTestStream(t *testing.T, itag int) (*StreamReader, *atomic.Int32, []byte) {
+	t.Helper()
+
+	var requests atomic.Int32
+	fixtures := youtubedltest.NewHandler("testdata")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/videoplayback" {
+			requests.Add(1)
+		}
+		fixtures.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := New(WithHTTPClient(srv.Client()), WithEndpoints(Endpoints{Base: srv.URL, Stream: srv.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	stream, err := client.OpenStream(context.Background(), video, &video.Formats.Itag(itag)[0])
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	t.Cleanup(func() { stream.Close() })
+	stream.blockSize = 10 * Size1Kb
+
+	want, err := os.ReadFile("testdata/videoplayback/o-fixture." + strconv.Itoa(itag))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stream, &requests, want
+}
+
+func TestStreamReader(t *testing.T) {
+	stream, _, want := openTestStream(t, 137)
+
+	if stream.Size() != int64(len(want)) {
+		t.Errorf("Size() = %d, want %d", stream.Size(), len(want))
+	}
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("stream content differs from fixture")
+	}
+
+	// seek back and read across a block boundary
+	if pos, err := stream.Seek(-25000, io.SeekEnd); err != nil || pos != int64(len(want))-25000 {
+		t.Fatalf("Seek = %d, %v", pos, err)
+	}
+	buf := make([]byte, 12000)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		t.Fatalf("read after seek failed: %v", err)
+	}
+	if !bytes.Equal(buf, want[len(want)-25000:len(want)-13000]) {
+		t.Errorf("content after seek differs from fixture")
+	}
+
+	// concurrent random reads
+	var wg sync.WaitGroup
+	for _, off := range []int64{0, 10239, 33333, 70000, int64(len(want)) - 100} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			buf := make([]byte, 15000)
+			n, err := stream.ReadAt(buf, off)
+			if err != nil && !(err == io.EOF && off+int64(n) == int64(len(want))) {
+				t.Errorf("ReadAt(%d) failed: %v", off, err)
+			}
+			if !bytes.Equal(buf[:n], want[off:off+int64(n)]) {
+				t.Errorf("ReadAt(%d) content differs from fixture", off)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stream.Close()
+	if _, err := stream.Read(buf); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("Read after Close: err = %v, want ErrStreamClosed", err)
+	}
+}
+
+func TestStreamReaderCache(t *testing.T) {
+	stream, requests, want := openTestStream(t, 140)
+
+	buf := make([]byte, 5000)
+	for range 3 {
+		if _, err := stream.ReadAt(buf, 12000); err != nil {
+			t.Fatalf("ReadAt failed: %v", err)
+		}
+	}
+	if !bytes.Equal(buf, want[12000:17000]) {
+		t.Errorf("content differs from fixture")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d blocks requested, want 1", n)
+	}
+
+	// a sequential read fetches the following blocks ahead
+	if _, err := stream.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Read(buf); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	for _, index := range []int64{0, 1, 2} {
+		block := stream.block(index)
+		<-block.done
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d blocks requested, want 3", n)
+	}
+}
+
+func TestStreamReaderNoFormat(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	if _, err := client.OpenStream(context.Background(), video, nil); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("OpenStream(nil) err = %v, want ErrNoFormat", err)
+	}
+}
+
+func TestStreamReaderUnknownSize(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	fixtures := youtubedltest.NewHandler("testdata")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/videoplayback" {
+			mu.Lock()
+			requests = append(requests, r.Method+" "+r.URL.Query().Get("range"))
+			mu.Unlock()
+		}
+		fixtures.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	client, err := New(WithHTTPClient(srv.Client()), WithEndpoints(Endpoints{Base: srv.URL, Stream: srv.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	video, err := client.GetVideo(fixtureVideoID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+	format := video.Formats.Itag(140)[0]
+	format.ContentLength = 0
+
+	stream, err := client.OpenStream(context.Background(), video, &format)
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	want, _ := os.ReadFile("testdata/videoplayback/o-fixture.140")
+	if stream.Size() != int64(len(want)) {
+		t.Errorf("Size() = %d, want %d", stream.Size(), len(want))
+	}
+
+	// the size is read without downloading the stream
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || requests[0] != "HEAD " {
+		t.Errorf("requests = %q, want a single HEAD", requests)
+	}
+}
+
+func TestStreamReaderServeContent(t *testing.T) {
+	stream, _, want := openTestStream(t, 18)
+
+	req := httptest.NewRequest(http.MethodGet, "/video.mp4", nil)
+	req.Header.Set("Range", "bytes=15000-34999")
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, req, "video.mp4", time.Time{}, stream)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), want[15000:35000]) {
+		t.Errorf("served content differs from fixture")
+	}
+}
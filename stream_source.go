@@ -0,0 +1,143 @@
+package youtubedl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// streamExpiryMargin is how long before it expires a stream URL is resolved again.
+const streamExpiryMargin = 30 * time.Second
+
+// streamSource resolves the URL of a format for the duration of a download,
+// and resolves it again when it expires or googlevideo rejects it with 403.
+// The video and format belong to the caller and are only read; refreshed
+// URLs are kept in the source.
+type streamSource struct {
+	client *Client
+	video  *Video
+	format *Format
+
+	mu        sync.Mutex
+	url       string
+	expiresAt time.Time // when url stops working, zero if unknown
+	gen       int       // incremented on every refresh
+}
+
+func (c *Client) newStreamSource(video *Video, format *Format) *streamSource {
+	return &streamSource{
+		client:    c,
+		video:     video,
+		format:    format,
+		expiresAt: format.ExpiresAt,
+	}
+}
+
+func (s *streamSource) expired() bool {
+	return !s.expiresAt.IsZero() && time.Now().Add(streamExpiryMargin).After(s.expiresAt)
+}
+
+// get returns the stream URL and its generation, resolving it on first use
+// and once it expired.
+func (s *streamSource) get(ctx context.Context) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired() {
+		if err := s.refreshLocked(ctx); err != nil {
+			return "", 0, err
+		}
+	}
+
+	if s.url == "" {
+		uri, err := s.client.GetStreamURLContext(ctx, s.video, s.format)
+		if err != nil {
+			return "", 0, err
+		}
+		s.url = uri
+	}
+
+	return s.url, s.gen, nil
+}
+
+// refresh resolves the URL again, unless another caller already replaced
+// the URL of generation gen.
+func (s *streamSource) refresh(ctx context.Context, gen int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if gen != s.gen {
+		return nil
+	}
+
+	return s.refreshLocked(ctx)
+}
+
+// refreshLocked fetches the player response again and keeps the new URL of
+// the same itag, unless the stream behind it changed.
+func (s *streamSource) refreshLocked(ctx context.Context) error {
+	fresh, err := s.client.fetchVideo(ctx, s.video.ID, s.video.formatClient(s.format))
+	if err != nil {
+		return fmt.Errorf("unable to refresh stream URL: %w", err)
+	}
+
+	format := fresh.Formats.sameAs(s.format)
+	if format == nil {
+		return fmt.Errorf("unable to refresh stream URL: itag %d no longer available", s.format.ItagNo)
+	}
+	if format.LastModified != s.format.LastModified || format.ContentLength != s.format.ContentLength {
+		return fmt.Errorf("unable to refresh stream URL: %w: itag %d", ErrStreamChanged, s.format.ItagNo)
+	}
+
+	uri, err := s.client.GetStreamURLContext(ctx, fresh, format)
+	if err != nil {
+		return err
+	}
+
+	s.url = uri
+	s.expiresAt = format.ExpiresAt
+	s.gen++
+
+	return nil
+}
+
+// do requests the stream, with its query altered by modify if not nil.
+// The URL is resolved again once if googlevideo rejects it.
+func (s *streamSource) do(ctx context.Context, modify func(url.Values)) (*http.Response, error) {
+	return s.request(ctx, http.MethodGet, modify)
+}
+
+// request is do with another method than GET.
+func (s *streamSource) request(ctx context.Context, method string, modify func(url.Values)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		uri, gen, err := s.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if modify != nil {
+			q := req.URL.Query()
+			modify(q)
+			req.URL.RawQuery = q.Encode()
+		}
+
+		resp, err := httpDo(ctx, req)
+		if attempt == 0 && errors.Is(err, ErrUnexpectedStatusCode(http.StatusForbidden)) {
+			if err := s.refresh(ctx, gen); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return resp, err
+	}
+}
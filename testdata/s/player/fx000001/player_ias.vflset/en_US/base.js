@@ -0,0 +1,10 @@
+var _yt_player={};(function(g){var window=this;
+var XX="abc;def;-_w8_;xyz;length".split(";");
+var Xy={Xk:function(a){a.reverse()},
+zd:function(a,b){a.splice(0,b)},
+hP:function(a,b){var c=a[0];a[0]=a[b%a.length];a[b%a.length]=c}};
+Zf=function(a){a=a.split("");Xy.Xk(a,0);Xy.zd(a,2);Xy.hP(a,5);return a.join("")};
+Nx=function(a){if(typeof Qq==="undefined")return a;
+var b=a.split(""),c=new Date(XX[0].length);b.reverse();return b.join("")+XX[3]};
+g.config={signatureTimestamp:20000,sts:1};
+})(_yt_player);
@@ -2,10 +2,9 @@ package youtubedl
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
+	"log/slog"
 	"net/url"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -13,20 +12,42 @@ import (
 )
 
 type Video struct {
-	ID              string
-	Title           string
-	Description     string
-	Author          string
-	ChannelID       string
-	ChannelHandle   string
-	Views           int
-	Duration        time.Duration
-	PublishDate     time.Time
+	ID            string
+	Title         string
+	Description   string
+	Author        string
+	ChannelID     string
+	ChannelHandle string
+	Views         int
+	Duration      time.Duration
+	PublishDate   time.Time
+	UploadDate    time.Time
+	Keywords      []string
+	Category      string
+	AverageRating float64
+	IsLiveContent bool
+	IsPrivate     bool
+	IsUnlisted    bool
+	IsFamilySafe  bool
+
+	// AvailableCountries holds the ISO 3166 codes of the regions the video
+	// is available in, nil when the server does not list them
+	AvailableCountries map[string]bool
+
 	Formats         FormatList
 	Thumbnails      []Thumbnail
-	DASHManifestURL string // URI of the DASH manifest file
-	HLSManifestURL  string // URI of the HLS manifest file
-	client          *YoutubeClient
+	DASHManifestURL string    // URI of the DASH manifest file
+	HLSManifestURL  string    // URI of the HLS manifest file
+	FetchedAt       time.Time // when the player response was fetched
+	ExpiresAt       time.Time // when the stream URLs of Formats expire
+
+	// Likes, Chapters and Related are only found on the watch page, read
+	// when the video cannot be played in embeds
+	Likes    int
+	Chapters []Chapter
+	Related  []RelatedVideo
+
+	client *YoutubeClient
 }
 
 type Thumbnail struct {
@@ -35,7 +56,11 @@ type Thumbnail struct {
 	Height uint
 }
 type videooptions struct {
-	client string
+	client   string
+	decipher bool
+	rankers  []FormatRanker
+	fallback []string
+	merge    bool
 }
 
 type VideoOpts func(*videooptions)
@@ -46,8 +71,62 @@ func WithClient(client string) VideoOpts {
 	}
 }
 
+// WithDecipheredURLs resolves the signature and n parameter of every format
+// while fetching the video, filling in Format.DecipheredURL.
+func WithDecipheredURLs() VideoOpts {
+	return func(o *videooptions) {
+		o.decipher = true
+	}
+}
+
+// WithClientFallback tries the clients in order instead of the one given to
+// WithClient, going on with the next one when a client is refused playback
+// or gets no formats.
+func WithClientFallback(clients ...string) VideoOpts {
+	return func(o *videooptions) {
+		o.fallback = append(o.fallback, clients...)
+	}
+}
+
+// WithMergedFormats goes on with the remaining clients of WithClientFallback
+// once one succeeded, adding the formats it lacks from the others.
+func WithMergedFormats() VideoOpts {
+	return func(o *videooptions) {
+		o.merge = true
+	}
+}
+
+// WithFormatRanker sorts the formats of the video with the rankers, as in
+// FormatList.Sort.
+func WithFormatRanker(rankers ...FormatRanker) VideoOpts {
+	return func(o *videooptions) {
+		o.rankers = append(o.rankers, rankers...)
+	}
+}
+
 const dateFormat = "2006-01-02"
 
+// parseMicroformatDate parses the dates of the microformat, either a day or
+// a time with its offset, returning the zero time if it cannot.
+func parseMicroformatDate(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+
+	t, _ := time.Parse(dateFormat, s)
+	return t
+}
+
+// Available reports whether the video can be watched in region, an ISO 3166
+// code such as US. Videos whose regions are not listed are taken as
+// available everywhere.
+func (v *Video) Available(region string) bool {
+	if v.AvailableCountries == nil {
+		return true
+	}
+	return v.AvailableCountries[strings.ToUpper(region)]
+}
+
 func (v *Video) parseVideoInfo(body []byte) error {
 	var prData playerResponseData
 	if err := json.Unmarshal(body, &prData); err != nil {
@@ -65,16 +144,14 @@ func (v *Video) isVideoFromInfoDownloadable(prData playerResponseData) error {
 	return v.isVideoDownloadable(prData, false)
 }
 
-var playerResponsePattern = regexp.MustCompile(`var ytInitialPlayerResponse\s*=\s*(\{.+?\});`)
-
 func (v *Video) parseVideoPage(body []byte) error {
-	initialPlayerResponse := playerResponsePattern.FindSubmatch(body)
-	if initialPlayerResponse == nil || len(initialPlayerResponse) < 2 {
-		return errors.New("no ytInitialPlayerResponse found in the server's answer")
+	playerResponse, err := extractPageObject(body, "ytInitialPlayerResponse")
+	if err != nil {
+		return err
 	}
 
 	var prData playerResponseData
-	if err := json.Unmarshal(initialPlayerResponse[1], &prData); err != nil {
+	if err := json.Unmarshal(playerResponse, &prData); err != nil {
 		return fmt.Errorf("unable to parse player response JSON: %w", err)
 	}
 
@@ -82,7 +159,24 @@ func (v *Video) parseVideoPage(body []byte) error {
 		return err
 	}
 
-	return v.extractDataFromPlayerResponse(prData)
+	if err := v.extractDataFromPlayerResponse(prData); err != nil {
+		return err
+	}
+
+	// the player response is enough, the initial data only adds to it
+	page, err := extractPageObject(body, "ytInitialData")
+	if err != nil {
+		return nil
+	}
+
+	var data initialData
+	if err := json.Unmarshal(page, &data); err != nil {
+		slog.Debug("failed to parse initial data", "video", v.ID, "error", err)
+		return nil
+	}
+	v.extractDataFromInitialData(data)
+
+	return nil
 }
 
 func (v *Video) isVideoFromPageDownloadable(prData playerResponseData) error {
@@ -131,8 +225,22 @@ func (v *Video) extractDataFromPlayerResponse(prData playerResponseData) error {
 		v.Duration = time.Duration(seconds) * time.Second
 	}
 
-	if str := prData.Microformat.PlayerMicroformatRenderer.PublishDate; str != "" {
-		v.PublishDate, _ = time.Parse(dateFormat, str)
+	microformat := prData.Microformat.PlayerMicroformatRenderer
+	v.PublishDate = parseMicroformatDate(microformat.PublishDate)
+	v.UploadDate = parseMicroformatDate(microformat.UploadDate)
+	v.Keywords = prData.VideoDetails.Keywords
+	v.Category = microformat.Category
+	v.AverageRating = prData.VideoDetails.AverageRating
+	v.IsLiveContent = prData.VideoDetails.IsLiveContent
+	v.IsPrivate = prData.VideoDetails.IsPrivate
+	v.IsUnlisted = microformat.IsUnlisted
+	v.IsFamilySafe = microformat.IsFamilySafe
+
+	if countries := microformat.AvailableCountries; countries != nil {
+		v.AvailableCountries = make(map[string]bool, len(countries))
+		for _, country := range countries {
+			v.AvailableCountries[strings.ToUpper(country)] = true
+		}
 	}
 
 	if profileURL, err := url.Parse(prData.Microformat.PlayerMicroformatRenderer.OwnerProfileURL); err == nil && len(profileURL.Path) > 1 {
@@ -142,7 +250,19 @@ func (v *Video) extractDataFromPlayerResponse(prData playerResponseData) error {
 	// Assign Streams
 	v.Formats = append(prData.StreamingData.Formats, prData.StreamingData.AdaptiveFormats...)
 	if len(v.Formats) == 0 {
-		return errors.New("no formats found in the server's answer")
+		return ErrNoFormats
+	}
+
+	for i := range v.Formats {
+		v.Formats[i].Client = v.client
+	}
+
+	v.FetchedAt = time.Now()
+	if seconds, _ := strconv.Atoi(prData.StreamingData.ExpiresInSeconds); seconds > 0 {
+		v.ExpiresAt = v.FetchedAt.Add(time.Duration(seconds) * time.Second)
+		for i := range v.Formats {
+			v.Formats[i].ExpiresAt = v.ExpiresAt
+		}
 	}
 
 	// Sort formats by bitrate
@@ -154,6 +274,33 @@ func (v *Video) extractDataFromPlayerResponse(prData playerResponseData) error {
 	return nil
 }
 
+// formatClient returns the client f was fetched with.
+func (v *Video) formatClient(f *Format) *YoutubeClient {
+	if f.Client != nil {
+		return f.Client
+	}
+	return v.client
+}
+
+// mergeFormats adds the formats of other which v lacks.
+func (v *Video) mergeFormats(other *Video) {
+	for _, f := range other.Formats {
+		if v.Formats.sameAs(&f) == nil {
+			v.Formats = append(v.Formats, f)
+		}
+	}
+
+	if !other.ExpiresAt.IsZero() && (v.ExpiresAt.IsZero() || other.ExpiresAt.Before(v.ExpiresAt)) {
+		v.ExpiresAt = other.ExpiresAt
+	}
+	if v.HLSManifestURL == "" {
+		v.HLSManifestURL = other.HLSManifestURL
+	}
+	if v.DASHManifestURL == "" {
+		v.DASHManifestURL = other.DASHManifestURL
+	}
+}
+
 func (v *Video) SortBitrateDesc(i int, j int) bool {
 	return v.Formats[i].Bitrate > v.Formats[j].Bitrate
 }
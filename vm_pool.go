@@ -0,0 +1,129 @@
+package youtubedl
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// decipherVM is a goja runtime with a player's decipher functions loaded.
+type decipherVM struct {
+	vm   *goja.Runtime
+	sig  func(string) (string, error)
+	nsig func(string) (string, error)
+}
+
+// vmPool keeps ready decipherVMs for one player. A goja.Runtime is not safe
+// for concurrent use, so each VM is handed to a single caller at a time.
+type vmPool struct {
+	once sync.Once
+	err  error
+	sig  *goja.Program
+	nsig *goja.Program
+	pool sync.Pool
+}
+
+// compile compiles the player's decipher code, once.
+func (pool *vmPool) compile(p *Player) error {
+	pool.once.Do(func() {
+		if p.sig_sc != "" {
+			// the trailing call is only needed by code running sig_sc directly
+			src := strings.TrimSuffix(p.sig_sc, " descramble_sig(sig);")
+			pool.sig, pool.err = goja.Compile("sig", src, false)
+			if pool.err != nil {
+				return
+			}
+		}
+
+		if p.nsig_sc != "" {
+			pool.nsig, pool.err = goja.Compile("nsig", p.nsig_sc, false)
+		}
+	})
+
+	return pool.err
+}
+
+func (pool *vmPool) get(p *Player) (*decipherVM, error) {
+	if vm, ok := pool.pool.Get().(*decipherVM); ok {
+		return vm, nil
+	}
+
+	if err := pool.compile(p); err != nil {
+		return nil, err
+	}
+
+	d := &decipherVM{vm: goja.New()}
+
+	if pool.sig != nil {
+		if _, err := d.vm.RunProgram(pool.sig); err != nil {
+			return nil, err
+		}
+
+		if err := d.vm.ExportTo(d.vm.Get("descramble_sig"), &d.sig); err != nil {
+			return nil, err
+		}
+	}
+
+	if pool.nsig != nil {
+		if err := d.vm.Set(p.nsig_check, true); err != nil {
+			return nil, err
+		}
+
+		if _, err := d.vm.RunProgram(pool.nsig); err != nil {
+			return nil, err
+		}
+
+		fn := d.vm.Get(p.nsig_name)
+		if fn == nil {
+			return nil, fmt.Errorf("nsig function %q not found", p.nsig_name)
+		}
+
+		if err := d.vm.ExportTo(fn, &d.nsig); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (pool *vmPool) put(vm *decipherVM) {
+	pool.pool.Put(vm)
+}
+
+// run calls f with a VM from the pool. VMs are only returned to the pool
+// when f succeeds, as a thrown exception may leave the runtime in any state.
+func (pool *vmPool) run(p *Player, f func(*decipherVM) (string, error)) (string, error) {
+	vm, err := pool.get(p)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := f(vm)
+	if err != nil {
+		return "", err
+	}
+
+	pool.put(vm)
+
+	return out, nil
+}
+
+func (p *Player) descrambleSig(s string) (string, error) {
+	return p.vms.run(p, func(vm *decipherVM) (string, error) {
+		if vm.sig == nil {
+			return "", ErrCipherNotFound
+		}
+		return vm.sig(s)
+	})
+}
+
+func (p *Player) descrambleN(n string) (string, error) {
+	return p.vms.run(p, func(vm *decipherVM) (string, error) {
+		if vm.nsig == nil {
+			return n, nil
+		}
+		return vm.nsig(n)
+	})
+}
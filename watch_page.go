@@ -0,0 +1,286 @@
+package youtubedl
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chapter is a chapter of a video, from its description or generated.
+type Chapter struct {
+	Title      string
+	Start      time.Duration
+	End        time.Duration // the start of the next chapter, or the duration of the video
+	Thumbnails []Thumbnail
+}
+
+// RelatedVideo is a video suggested next to a video on its watch page.
+type RelatedVideo struct {
+	ID         string
+	Title      string
+	Author     string
+	Duration   time.Duration // zero when unknown, such as for live streams
+	Thumbnails []Thumbnail
+}
+
+// extractPageObject returns the JSON object a watch page assigns to the
+// variable name, as in var ytInitialData = {...}; or
+// window["ytInitialData"] = {...};. The object ends at the brace balancing
+// its first one, braces in strings aside.
+func extractPageObject(page []byte, name string) ([]byte, error) {
+	for offset := 0; ; {
+		i := bytes.Index(page[offset:], []byte(name))
+		if i < 0 {
+			return nil, fmt.Errorf("no %s found in the server's answer", name)
+		}
+		offset += i + len(name)
+
+		rest := bytes.TrimPrefix(page[offset:], []byte(`"]`))
+		rest = bytes.TrimLeft(rest, " \t\r\n")
+		if len(rest) == 0 || rest[0] != '=' {
+			continue
+		}
+		rest = bytes.TrimLeft(rest[1:], " \t\r\n")
+		if len(rest) == 0 || rest[0] != '{' {
+			continue
+		}
+
+		if end := jsonObjectEnd(rest); end > 0 {
+			return rest[:end], nil
+		}
+
+		return nil, fmt.Errorf("unterminated %s in the server's answer", name)
+	}
+}
+
+// jsonObjectEnd returns the length of the JSON object data starts with, or
+// zero if it does not end.
+func jsonObjectEnd(data []byte) int {
+	depth := 0
+	inString, escaped := false, false
+
+	for i, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return 0
+}
+
+// extractDataFromInitialData sets the likes, chapters and related videos of
+// a watch page.
+func (v *Video) extractDataFromInitialData(data initialData) {
+	for _, mutation := range data.FrameworkUpdates.EntityBatchUpdate.Mutations {
+		if entity := mutation.Payload.LikeCountEntity; entity != nil {
+			v.Likes, _ = strconv.Atoi(entity.LikeCountIfIndifferentNumber)
+			break
+		}
+	}
+
+	bar := data.PlayerOverlays.PlayerOverlayRenderer.DecoratedPlayerBarRenderer.DecoratedPlayerBarRenderer.PlayerBar.MultiMarkersPlayerBarRenderer
+	for _, marker := range bar.MarkersMap {
+		if marker.Key != "DESCRIPTION_CHAPTERS" && marker.Key != "AUTO_CHAPTERS" {
+			continue
+		}
+
+		v.Chapters = make([]Chapter, 0, len(marker.Value.Chapters))
+		for _, c := range marker.Value.Chapters {
+			v.Chapters = append(v.Chapters, Chapter{
+				Title:      c.ChapterRenderer.Title.SimpleText,
+				Start:      time.Duration(c.ChapterRenderer.TimeRangeStartMillis) * time.Millisecond,
+				Thumbnails: c.ChapterRenderer.Thumbnail.Thumbnails,
+			})
+		}
+		for i := range v.Chapters {
+			if i+1 < len(v.Chapters) {
+				v.Chapters[i].End = v.Chapters[i+1].Start
+			} else {
+				v.Chapters[i].End = v.Duration
+			}
+		}
+		break
+	}
+
+	for _, item := range data.Contents.TwoColumnWatchNextResults.SecondaryResults.SecondaryResults.Results {
+		switch {
+		case item.CompactVideoRenderer != nil:
+			r := item.CompactVideoRenderer
+			v.Related = append(v.Related, RelatedVideo{
+				ID:         r.VideoID,
+				Title:      r.Title.SimpleText,
+				Author:     r.LongBylineText.String(),
+				Duration:   parseClock(r.LengthText.SimpleText),
+				Thumbnails: r.Thumbnail.Thumbnails,
+			})
+
+		case item.LockupViewModel != nil && item.LockupViewModel.ContentType == "LOCKUP_CONTENT_TYPE_VIDEO":
+			r := item.LockupViewModel
+			related := RelatedVideo{
+				ID:    r.ContentID,
+				Title: r.Metadata.LockupMetadataViewModel.Title.Content,
+			}
+			if rows := r.Metadata.LockupMetadataViewModel.Metadata.ContentMetadataViewModel.MetadataRows; len(rows) > 0 && len(rows[0].MetadataParts) > 0 {
+				related.Author = rows[0].MetadataParts[0].Text.Content
+			}
+			for _, overlay := range r.ContentImage.ThumbnailViewModel.Overlays {
+				for _, badge := range overlay.ThumbnailOverlayBadgeViewModel.ThumbnailBadges {
+					if d := parseClock(badge.ThumbnailBadgeViewModel.Text); d > 0 {
+						related.Duration = d
+					}
+				}
+			}
+			for _, source := range r.ContentImage.ThumbnailViewModel.Image.Sources {
+				related.Thumbnails = append(related.Thumbnails, Thumbnail(source))
+			}
+			v.Related = append(v.Related, related)
+		}
+	}
+}
+
+// parseClock parses a duration written as 4:13 or 1:02:03, returning zero
+// for anything else.
+func parseClock(s string) time.Duration {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0
+	}
+
+	var seconds int
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+type simpleText struct {
+	SimpleText string `json:"simpleText"`
+}
+
+type thumbnails struct {
+	Thumbnails []Thumbnail `json:"thumbnails"`
+}
+
+// initialData is the part of the ytInitialData of a watch page that is used.
+type initialData struct {
+	Contents struct {
+		TwoColumnWatchNextResults struct {
+			SecondaryResults struct {
+				SecondaryResults struct {
+					Results []struct {
+						CompactVideoRenderer *compactVideoRenderer `json:"compactVideoRenderer"`
+						LockupViewModel      *lockupViewModel      `json:"lockupViewModel"`
+					} `json:"results"`
+				} `json:"secondaryResults"`
+			} `json:"secondaryResults"`
+		} `json:"twoColumnWatchNextResults"`
+	} `json:"contents"`
+
+	PlayerOverlays struct {
+		PlayerOverlayRenderer struct {
+			DecoratedPlayerBarRenderer struct {
+				DecoratedPlayerBarRenderer struct {
+					PlayerBar struct {
+						MultiMarkersPlayerBarRenderer struct {
+							MarkersMap []struct {
+								Key   string `json:"key"`
+								Value struct {
+									Chapters []struct {
+										ChapterRenderer struct {
+											Title                simpleText `json:"title"`
+											TimeRangeStartMillis int64      `json:"timeRangeStartMillis"`
+											Thumbnail            thumbnails `json:"thumbnail"`
+										} `json:"chapterRenderer"`
+									} `json:"chapters"`
+								} `json:"value"`
+							} `json:"markersMap"`
+						} `json:"multiMarkersPlayerBarRenderer"`
+					} `json:"playerBar"`
+				} `json:"decoratedPlayerBarRenderer"`
+			} `json:"decoratedPlayerBarRenderer"`
+		} `json:"playerOverlayRenderer"`
+	} `json:"playerOverlays"`
+
+	FrameworkUpdates struct {
+		EntityBatchUpdate struct {
+			Mutations []struct {
+				Payload struct {
+					LikeCountEntity *struct {
+						LikeCountIfIndifferentNumber string `json:"likeCountIfIndifferentNumber"`
+					} `json:"likeCountEntity"`
+				} `json:"payload"`
+			} `json:"mutations"`
+		} `json:"entityBatchUpdate"`
+	} `json:"frameworkUpdates"`
+}
+
+type compactVideoRenderer struct {
+	VideoID        string     `json:"videoId"`
+	Title          simpleText `json:"title"`
+	LongBylineText withRuns   `json:"longBylineText"`
+	LengthText     simpleText `json:"lengthText"`
+	Thumbnail      thumbnails `json:"thumbnail"`
+}
+
+type lockupViewModel struct {
+	ContentID    string `json:"contentId"`
+	ContentType  string `json:"contentType"`
+	ContentImage struct {
+		ThumbnailViewModel struct {
+			Image struct {
+				Sources []struct {
+					URL    string `json:"url"`
+					Width  uint   `json:"width"`
+					Height uint   `json:"height"`
+				} `json:"sources"`
+			} `json:"image"`
+			Overlays []struct {
+				ThumbnailOverlayBadgeViewModel struct {
+					ThumbnailBadges []struct {
+						ThumbnailBadgeViewModel struct {
+							Text string `json:"text"`
+						} `json:"thumbnailBadgeViewModel"`
+					} `json:"thumbnailBadges"`
+				} `json:"thumbnailOverlayBadgeViewModel"`
+			} `json:"overlays"`
+		} `json:"thumbnailViewModel"`
+	} `json:"contentImage"`
+	Metadata struct {
+		LockupMetadataViewModel struct {
+			Title struct {
+				Content string `json:"content"`
+			} `json:"title"`
+			Metadata struct {
+				ContentMetadataViewModel struct {
+					MetadataRows []struct {
+						MetadataParts []struct {
+							Text struct {
+								Content string `json:"content"`
+							} `json:"text"`
+						} `json:"metadataParts"`
+					} `json:"metadataRows"`
+				} `json:"contentMetadataViewModel"`
+			} `json:"metadata"`
+		} `json:"lockupMetadataViewModel"`
+	} `json:"metadata"`
+}
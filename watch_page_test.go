@@ -0,0 +1,126 @@
+package youtubedl
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractPageObject(t *testing.T) {
+	tests := []struct {
+		page string
+		want string
+	}{
+		{`<script>var ytInitialData = {"a":{"b":1}};</script>`, `{"a":{"b":1}}`},
+		{`<script>window["ytInitialData"] = {"a":1};</script>`, `{"a":1}`},
+		{`var ytInitialData={"a":"};"};var x = {};`, `{"a":"};"}`},
+		{`var ytInitialData = {"a":"\"}\\","b":"{"};`, `{"a":"\"}\\","b":"{"}`},
+		{`{"key":"ytInitialData"} ytInitialData; var ytInitialData =
+			{"a":[{}]};`, `{"a":[{}]}`},
+	}
+
+	for _, tt := range tests {
+		got, err := extractPageObject([]byte(tt.page), "ytInitialData")
+		if err != nil || string(got) != tt.want {
+			t.Errorf("extractPageObject(%q) = %s, %v, want %s", tt.page, got, err, tt.want)
+		}
+	}
+
+	for _, page := range []string{
+		`var ytInitialPlayerResponse = {};`,
+		`var ytInitialData = "{}";`,
+		`var ytInitialData = {"a":{"b":1};`,
+	} {
+		if got, err := extractPageObject([]byte(page), "ytInitialData"); err == nil {
+			t.Errorf("extractPageObject(%q) = %s, want an error", page, got)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"4:13":    4*time.Minute + 13*time.Second,
+		"1:02:03": time.Hour + 2*time.Minute + 3*time.Second,
+		"0:10":    10 * time.Second,
+		"LIVE":    0,
+		"13":      0,
+		"1:-2":    0,
+		"":        0,
+	} {
+		if got := parseClock(s); got != want {
+			t.Errorf("parseClock(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestGetVideoWatchPage(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureWatchPageID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	if video.Title != "Fixture Not Embeddable" {
+		t.Errorf("video.Title = %q", video.Title)
+	}
+	if !strings.HasSuffix(video.Description, `var broken = "};</script>";`) {
+		t.Errorf("video.Description = %q", video.Description)
+	}
+	if got := rankedItags(video.Formats); got != "136 247 140 251" {
+		t.Errorf("formats = %s", got)
+	}
+	if video.Likes != 4242 {
+		t.Errorf("video.Likes = %d, want 4242", video.Likes)
+	}
+
+	chapters := []Chapter{
+		{Title: "Intro {", Start: 0, End: 4 * time.Second},
+		{Title: "Outro };", Start: 4 * time.Second, End: 10 * time.Second},
+	}
+	if len(video.Chapters) != len(chapters) {
+		t.Fatalf("video.Chapters = %+v", video.Chapters)
+	}
+	for i, want := range chapters {
+		got := video.Chapters[i]
+		if got.Title != want.Title || got.Start != want.Start || got.End != want.End || len(got.Thumbnails) != 1 {
+			t.Errorf("chapter %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	related := []RelatedVideo{
+		{ID: "fixture0001", Title: "Fixture Video", Author: "Fixture Channel", Duration: 3*time.Minute + 32*time.Second},
+		{ID: "fixture0002", Title: "Fixture Media", Author: "Fixture Channel", Duration: 10 * time.Second},
+	}
+	if len(video.Related) != len(related) {
+		t.Fatalf("video.Related = %+v", video.Related)
+	}
+	for i, want := range related {
+		got := video.Related[i]
+		if got.ID != want.ID || got.Title != want.Title || got.Author != want.Author || got.Duration != want.Duration {
+			t.Errorf("related video %d = %+v, want %+v", i, got, want)
+		}
+		if len(got.Thumbnails) != 1 || got.Thumbnails[0].Width != 120 {
+			t.Errorf("related video %d: thumbnails = %+v", i, got.Thumbnails)
+		}
+	}
+}
+
+func TestGetVideoBrokenInitialData(t *testing.T) {
+	client := newTestClient(t)
+
+	video, err := client.GetVideo(fixtureBrokenDataID)
+	if err != nil {
+		t.Fatalf("GetVideo failed: %v", err)
+	}
+
+	if video.Title != "Fixture Broken Initial Data" {
+		t.Errorf("video.Title = %q", video.Title)
+	}
+	if got := rankedItags(video.Formats); got != "136 247 140 251" {
+		t.Errorf("formats = %s", got)
+	}
+	if video.Likes != 0 || video.Chapters != nil || video.Related != nil {
+		t.Errorf("initial data used: likes=%d chapters=%+v related=%+v", video.Likes, video.Chapters, video.Related)
+	}
+}
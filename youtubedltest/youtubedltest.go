@@ -0,0 +1,276 @@
+// Package youtubedltest provides a fake InnerTube and googlevideo server
+// that replays recorded fixtures, and a Recorder to capture them.
+//
+// Fixtures are plain files laid out after the request they answer:
+//
+//	sw.js_data
+//	iframe_api
+//	s/player/<player id>/player_ias.vflset/en_US/base.js
+//	watch/<video id>.html
+//	youtubei/v1/player/<video id>.json
+//	youtubei/v1/player/<video id>.<client name>.json
+//	youtubei/v1/browse/<browse id>.json
+//	youtubei/v1/browse/continuation/<token hash>.json
+//	videoplayback/<id>.<itag>
+//	vi/<video id>/<thumbnail>.jpg
+//
+// Player responses of clients other than WEB are kept apart, with the
+// InnerTube client name in their file name.
+//
+// Stream fixtures hold the whole stream; ranged requests, using either the
+// range query parameter or a Range header, are served from them.
+package youtubedltest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// webClient is the client whose player responses have no client name in
+// their fixture file.
+const webClient = "WEB"
+
+// RecordEnv is the environment variable tests can check to record fixtures
+// from the live service instead of replaying them.
+const RecordEnv = "YOUTUBEDL_RECORD"
+
+// Recording reports whether RecordEnv is set.
+func Recording() bool {
+	return os.Getenv(RecordEnv) != ""
+}
+
+// Server is a fake InnerTube and googlevideo server.
+type Server struct {
+	*httptest.Server
+}
+
+// NewServer starts a Server replaying the fixtures in dir.
+// The caller should call Close when finished.
+func NewServer(dir string) *Server {
+	return &Server{httptest.NewServer(NewHandler(dir))}
+}
+
+// NewHandler returns the handler used by Server.
+func NewHandler(dir string) http.Handler {
+	return &handler{dir: dir}
+}
+
+type handler struct {
+	dir string
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name, err := FixturePath(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(h.dir, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "no fixture "+name, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if q := r.URL.Query().Get("range"); q != "" {
+		data, err = sliceRange(data, q)
+	} else if hdr := r.Header.Get("Range"); hdr != "" {
+		start := len(data)
+		data, err = sliceRange(data, strings.TrimPrefix(hdr, "bytes="))
+		if err == nil {
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %s/%d", strings.TrimPrefix(hdr, "bytes="), start))
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	w.Write(data) //nolint:errcheck
+}
+
+// sliceRange returns the inclusive byte range "start-end" of data.
+func sliceRange(data []byte, spec string) ([]byte, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid range %q", spec)
+	}
+
+	start, err := strconv.Atoi(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q", spec)
+	}
+
+	end := len(data) - 1
+	if to != "" {
+		if end, err = strconv.Atoi(to); err != nil {
+			return nil, fmt.Errorf("invalid range %q", spec)
+		}
+	}
+	if end > len(data)-1 {
+		end = len(data) - 1
+	}
+
+	if start < 0 || start > end {
+		return nil, fmt.Errorf("range %q not satisfiable", spec)
+	}
+
+	return data[start : end+1], nil
+}
+
+// FixturePath returns the fixture file, relative to the fixture directory,
+// that answers r. body is the already read request body.
+func FixturePath(r *http.Request, body []byte) (string, error) {
+	p := path.Clean("/" + r.URL.Path)
+	query := r.URL.Query()
+
+	switch p {
+	case "/youtubei/v1/player", "/youtubei/v1/browse":
+		var data struct {
+			VideoID      string `json:"videoId"`
+			BrowseID     string `json:"browseId"`
+			Continuation string `json:"continuation"`
+			Context      struct {
+				Client struct {
+					ClientName string `json:"clientName"`
+				} `json:"client"`
+			} `json:"context"`
+		}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return "", err
+		}
+
+		switch client := data.Context.Client.ClientName; {
+		case data.VideoID != "" && client != "" && client != webClient:
+			return p[1:] + "/" + data.VideoID + "." + client + ".json", nil
+		case data.VideoID != "":
+			return p[1:] + "/" + data.VideoID + ".json", nil
+		case data.BrowseID != "":
+			return p[1:] + "/" + data.BrowseID + ".json", nil
+		case data.Continuation != "":
+			return p[1:] + "/continuation/" + ContinuationHash(data.Continuation) + ".json", nil
+		}
+
+		return "", fmt.Errorf("no videoId, browseId or continuation in %s request", p)
+	case "/watch":
+		return "watch/" + query.Get("v") + ".html", nil
+	case "/videoplayback":
+		return "videoplayback/" + query.Get("id") + "." + query.Get("itag"), nil
+	case "/":
+		return "", errors.New("empty path")
+	}
+
+	return p[1:], nil
+}
+
+// ContinuationHash returns the file name used for a continuation token.
+func ContinuationHash(token string) string {
+	sum := sha1.Sum([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Recorder is an http.RoundTripper that forwards requests to Transport and
+// saves every successful response into Dir, in the layout served by Server.
+// Responses to HEAD requests have no body to save.
+type Recorder struct {
+	Dir string
+
+	// Transport defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	transport := rec.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || req.Method == http.MethodHead {
+		return resp, err
+	}
+
+	name, err := FixturePath(req, body)
+	if err != nil {
+		return resp, nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	offset := int64(-1)
+	if spec := req.URL.Query().Get("range"); spec != "" {
+		from, _, _ := strings.Cut(spec, "-")
+		offset, _ = strconv.ParseInt(from, 10, 64)
+	}
+
+	return resp, rec.save(name, offset, data)
+}
+
+// save writes data at offset into the fixture file name, so ranged stream
+// responses are stitched back into a whole stream. A negative offset
+// replaces the whole file.
+func (rec *Recorder) save(name string, offset int64, data []byte) error {
+	file := filepath.Join(rec.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset < 0 {
+		flags |= os.O_TRUNC
+		offset = 0
+	}
+
+	f, err := os.OpenFile(file, flags, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.WriteAt(data, offset); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
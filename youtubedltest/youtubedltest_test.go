@@ -0,0 +1,99 @@
+package youtubedltest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	stream := bytes.Repeat([]byte("0123456789"), 100)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/youtubei/v1/player":
+			io.WriteString(w, `{"videoId":"abc"}`) //nolint:errcheck
+		case "/videoplayback":
+			data, err := sliceRange(stream, r.URL.Query().Get("range"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Write(data) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	client := &http.Client{Transport: &Recorder{Dir: dir}}
+
+	resp, err := client.Post(upstream.URL+"/youtubei/v1/player", "application/json", strings.NewReader(`{"videoId":"abcdefghijk"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// record the stream out of order, as parallel chunk downloads do
+	for _, r := range []string{"500-999", "0-499"} {
+		resp, err := client.Get(upstream.URL + "/videoplayback?id=x&itag=18&range=" + r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "youtubei/v1/player/abcdefghijk.json")); err != nil {
+		t.Errorf("player response not recorded: %v", err)
+	}
+
+	srv := NewServer(dir)
+	defer srv.Close()
+
+	resp, err = srv.Client().Get(srv.URL + "/videoplayback?id=x&itag=18&range=10-19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if string(data) != "0123456789" {
+		t.Errorf("replayed range = %q", data)
+	}
+
+	recorded, _ := os.ReadFile(filepath.Join(dir, "videoplayback/x.18"))
+	if !bytes.Equal(recorded, stream) {
+		t.Errorf("recorded stream differs, got %d bytes", len(recorded))
+	}
+}
+
+func TestFixturePathClient(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"videoId":"abcdefghijk"}`, "youtubei/v1/player/abcdefghijk.json"},
+		{`{"videoId":"abcdefghijk","context":{"client":{"clientName":"WEB"}}}`, "youtubei/v1/player/abcdefghijk.json"},
+		{`{"videoId":"abcdefghijk","context":{"client":{"clientName":"iOS"}}}`, "youtubei/v1/player/abcdefghijk.iOS.json"},
+		{`{"browseId":"VLPLx","context":{"client":{"clientName":"iOS"}}}`, "youtubei/v1/browse/VLPLx.json"},
+	}
+
+	for _, tt := range tests {
+		p := "/youtubei/v1/player"
+		if strings.Contains(tt.body, "browseId") {
+			p = "/youtubei/v1/browse"
+		}
+		r := httptest.NewRequest(http.MethodPost, p, nil)
+
+		got, err := FixturePath(r, []byte(tt.body))
+		if err != nil || got != tt.want {
+			t.Errorf("FixturePath(%s) = %q, %v, want %q", tt.body, got, err, tt.want)
+		}
+	}
+}